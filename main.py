@@ -1,7 +1,12 @@
 #!/usr/bin/env python3
+import argparse
+import concurrent.futures
 import copy
 import datetime
 import doctest
+import fnmatch
+import hashlib
+import hmac
 from enum import StrEnum
 import json
 import html
@@ -9,6 +14,7 @@ import http.server
 import logging
 import os
 import random
+import re
 import socketserver
 import string
 import subprocess
@@ -16,7 +22,9 @@ import sys
 import threading
 import time
 import traceback
-from urllib.parse import parse_qsl
+import unicodedata
+from urllib.parse import parse_qs, parse_qsl, urlencode, urlsplit
+import zoneinfo
 
 import diskcache
 import jinja2
@@ -26,16 +34,85 @@ import yaml
 
 PORT = 16666
 
+# `gh search prs` only returns 30 results by default. It paginates internally (GitHub returns at most 100 results
+# per page) up to this limit, which is the maximum of the search API.
+PR_SEARCH_LIMIT = 1000
+
+# Long discussions and commit lists would bloat cache and database, while only recent entries are of interest
+MAX_STORED_COMMENTS_AND_COMMITS = 30
+# Inline code comments, only used for mention detection
+MAX_STORED_REVIEW_THREAD_COMMENTS = 30
+MAX_STORED_FILES = 100
+PR_KEY_PREFIX = 'pull_request.'
+# Status changes of each PR with their reason, for understanding why a PR shows up (or doesn't)
+STATUS_HISTORY_KEY_PREFIX = 'status_history.'
+MAX_STATUS_HISTORY_ENTRIES = 50
+MAX_DEFAULT_VIEW_QUERY_LENGTH = 1000
+MAX_IGNORED_REPO_PATTERNS = 100
+MAX_NOTE_LENGTH = 500
+MAX_LABELS_PER_PR = 20
+
+# GitHub caps webhook payloads at 25 MB
+MAX_WEBHOOK_BODY_BYTES = 25 * 1024 * 1024
+
+# Releases may never appear (e.g. renamed tag), so such snoozes end after this time
+SNOOZE_UNTIL_RELEASE_MAX_SECONDS = 86400 * 30
+
+# Authors may never split up their PR as promised, so such snoozes end after this time
+SNOOZE_UNTIL_SMALLER_MAX_SECONDS = 86400 * 14
+
+# Workflows may never run (e.g. only triggered on the default branch), so such snoozes end after this time
+SNOOZE_UNTIL_WORKFLOW_MAX_SECONDS = 86400 * 7
+
+# GitHub doesn't tell `gh` users how long a secondary rate limit lasts, so we pause all calls for this long
+RATE_LIMIT_BACKOFF_SECONDS = 60
+
+# Below this remaining quota (per API resource), the board shows stored data instead of making GitHub calls which
+# would fail halfway. `gh pr view` and `gh release list` use GraphQL, `gh search prs` the search API (30 per minute).
+RATE_LIMIT_MIN_REMAINING = {'graphql': 100, 'search': 10}
+
+# Remind the user to follow up if the person a review was delegated to didn't review within this time
+DELEGATE_FOLLOW_UP_SECONDS = 86400 * 3
+
+# Per-repo metrics only list the repos with most PRs, the rest is summed up as `other`, to keep the number of time
+# series bounded
+METRICS_MAX_REPOS = 20
+
+
+class GitHubCommandError(RuntimeError):
+    def __init__(self, message, stderr):
+        super().__init__(message)
+        self.stderr = stderr
+
+
+class GitHubRateLimitError(GitHubCommandError):
+    def __init__(self, message, stderr, retry_at):
+        super().__init__(message, stderr)
+        # Timestamp from which on GitHub calls are expected to work again
+        self.retry_at = retry_at
+
 
 class PullRequestStatus(StrEnum):
     # When adding new status values here, ensure amending all code that tries to handle every value
     # (e.g. CSS classes).
 
     CLOSED = 'closed'
+
+    # User handed the review over to someone else (see `delegated_to` field) and only wants to follow up
+    DELEGATED = 'delegated'
+
     DELETED = 'deleted'
+
+    # GitHub returns "not found" for the PR itself (e.g. deleted by GitHub support for spam), so it can't be fetched
+    # anymore. Deleted or inaccessible repos are covered by `REPO_GONE`.
+    GONE = 'gone'
+
     MERGED = 'merged'
     MUST_REVIEW = 'must-review'
 
+    # GitHub repo was deleted, or the user lost access to it, so the PR can't be fetched anymore
+    REPO_GONE = 'repo-gone'
+
     # User reviewed/updated the PR, and either merged it or expects it to be merged. If that happens, it should
     # be deleted from workboard storage. If not, it should pop up again (TODO this part isn't implemented yet).
     REVIEWED_DELETE_ON_MERGE = 'reviewed-delete-on-merge'
@@ -43,26 +120,68 @@ class PullRequestStatus(StrEnum):
     # Basically means that someone else takes care of the review. Only makes sense for PRs authored by others.
     SNOOZED_UNTIL_MENTIONED = 'snoozed-until-mentioned'
 
+    # Stacked PR which only matters once the PR it builds on is merged (see `snooze_until_merged_pr_url` field)
+    SNOOZED_UNTIL_OTHER_MERGED = 'snoozed-until-other-merged'
+
+    # Snoozed together with all other PRs of the repo until a given release tag exists (release coordination)
+    SNOOZED_UNTIL_RELEASE = 'snoozed-until-release'
+
+    # Draft PR of someone else, automatically snoozed until marked ready for review (`github.snooze_drafts` config)
+    SNOOZED_UNTIL_READY = 'snoozed-until-ready'
+
+    # Waiting for an oversized PR to be split up (see `snooze_until_smaller_than` field)
+    SNOOZED_UNTIL_SMALLER = 'snoozed-until-smaller'
+
     SNOOZED_UNTIL_TIME = 'snoozed-until-time'
     SNOOZED_UNTIL_UPDATE = 'snoozed-until-update'
+
+    # Waiting for a named workflow/check of the PR to finish, e.g. a deployment (see `snooze_until_workflow` field)
+    SNOOZED_UNTIL_WORKFLOW = 'snoozed-until-workflow'
+
     UPDATED_AFTER_SNOOZE = 'updated-after-snooze'
     UNKNOWN = 'unknown'
 
 PR_STATUS_SORT_ORDER = {
     str(PullRequestStatus.CLOSED): 1,
-    str(PullRequestStatus.DELETED): 999,  # not applicable since we filter those out for rendering
+    str(PullRequestStatus.DELEGATED): 5,
+    str(PullRequestStatus.DELETED): 999,  # only rendered if explicitly filtered, e.g. to restore a PR
+    str(PullRequestStatus.GONE): 1,
     str(PullRequestStatus.MERGED): 1,
     str(PullRequestStatus.MUST_REVIEW): 2,
+    str(PullRequestStatus.REPO_GONE): 1,
     str(PullRequestStatus.REVIEWED_DELETE_ON_MERGE): 5,
     str(PullRequestStatus.SNOOZED_UNTIL_MENTIONED): 5,
+    str(PullRequestStatus.SNOOZED_UNTIL_OTHER_MERGED): 5,
+    str(PullRequestStatus.SNOOZED_UNTIL_READY): 5,
+    str(PullRequestStatus.SNOOZED_UNTIL_RELEASE): 5,
+    str(PullRequestStatus.SNOOZED_UNTIL_SMALLER): 5,
     str(PullRequestStatus.SNOOZED_UNTIL_TIME): 5,
     str(PullRequestStatus.SNOOZED_UNTIL_UPDATE): 5,
+    str(PullRequestStatus.SNOOZED_UNTIL_WORKFLOW): 5,
     str(PullRequestStatus.UPDATED_AFTER_SNOOZE): 1,
     str(PullRequestStatus.UNKNOWN): 4,
 }
 assert all(str(status) in PR_STATUS_SORT_ORDER for status in PullRequestStatus), \
     'All PullRequestStatus enum values must be represented in PR_STATUS_SORT_ORDER'
 
+SNOOZED_PR_STATUSES = (
+    PullRequestStatus.SNOOZED_UNTIL_MENTIONED,
+    PullRequestStatus.SNOOZED_UNTIL_OTHER_MERGED,
+    PullRequestStatus.SNOOZED_UNTIL_READY,
+    PullRequestStatus.SNOOZED_UNTIL_RELEASE,
+    PullRequestStatus.SNOOZED_UNTIL_SMALLER,
+    PullRequestStatus.SNOOZED_UNTIL_TIME,
+    PullRequestStatus.SNOOZED_UNTIL_UPDATE,
+    PullRequestStatus.SNOOZED_UNTIL_WORKFLOW,
+)
+
+# Statuses where the user is expected to look at the PR next (used for the review queue)
+ACTIONABLE_PR_STATUSES = (
+    PullRequestStatus.MUST_REVIEW,
+    PullRequestStatus.UNKNOWN,
+    PullRequestStatus.UPDATED_AFTER_SNOOZE,
+)
+
 
 def github_datetime_to_timestamp(s):
     """
@@ -77,492 +196,3908 @@ def github_datetime_to_timestamp(s):
     return int(datetime.datetime.strptime(s, '%Y-%m-%dT%H:%M:%SZ').replace(tzinfo=datetime.timezone.utc).timestamp())
 
 
-def timed(desc, callback):
-    begin = time.perf_counter()
-    try:
-        ret = callback()
-    except:
-        end = time.perf_counter()
-        logging.error('%r raised an exception after %.2f second(s)', desc, end - begin)
-        raise
-    end = time.perf_counter()
-    logging.info('%r took %.2f second(s)', desc, end - begin)
-    return ret
+def sanitize_text(s, max_length):
+    r"""
+    Makes untrusted single-line text (e.g. PR titles) safe to store and render.
 
+    >>> sanitize_text('Fix\tthe   bug\r\n', max_length=100)
+    'Fix the bug'
+    >>> sanitize_text('Evil\x1b[31m\u202etitle\x00', max_length=100)
+    'Evil[31mtitle'
+    >>> sanitize_text('A' * 10, max_length=5)
+    'AAAA…'
+    >>> sanitize_text('Ünïcödé ✓', max_length=100)
+    'Ünïcödé ✓'
+    """
 
-class ServerHandler(http.server.SimpleHTTPRequestHandler):
-    # Must be set class-wide from configuration files (read-only)
-    cache = None
-    github_user = None
-    website_template = None
+    s = ''.join(
+        ' ' if c.isspace() else c
+        for c in s
+        # Control characters (Cc) and invisible formatting characters such as bidi overrides (Cf)
+        if c.isspace() or unicodedata.category(c) not in ('Cc', 'Cf'))
+    s = ' '.join(s.split())
+    if len(s) > max_length:
+        s = s[:max_length - 1] + '…'
+    return s
 
-    def _add_render_only_fields(self, pr):
-        pr = copy.deepcopy(pr)
-        pr['render_only_fields'] = {
-            'author_is_self': pr['github_fields']['author']['login'] == self.github_user,
-            'last_updated_desc': timeago.format(
-                datetime.datetime.fromtimestamp(github_datetime_to_timestamp(pr['github_fields']['updatedAt'])),
-                locale='en'),
-        }
-        return pr
 
-    def _cached_subprocess_check_output(self, *, cache_key, cache_duration_seconds, use_cache=True, mutate_before_store_in_cache=None, subprocess_kwargs):
-        with self.cache.transact():
-            if use_cache:
-                value = self.cache.get(cache_key)
-                if value is not None:
-                    logging.debug(
-                        'Using cached value for command output of cache key %r (cache duration: %s)',
-                        cache_key,
-                        cache_duration_seconds)
-                    return value
-            else:
-                logging.debug('Avoiding read from cache for cache key %r', cache_key)
-                self.cache.pop(cache_key)
+def trim_github_pr_fields(github_pr, github_user=None):
+    """
+    Older comments are dropped, except for the latest one mentioning the user so that mention detection still works on
+    chatty PRs.
 
-            logging.debug('Running command for cache key %r (cache duration: %ds)', cache_key, cache_duration_seconds)
-            proc = subprocess.Popen(**subprocess_kwargs, stdout=subprocess.PIPE, stderr=subprocess.PIPE)
-            (stdout, stderr) = proc.communicate()
-            if proc.returncode:
-                raise RuntimeError(f'Command failed for cache key {cache_key!r}. Error output was: {stderr!r}')
-            value = stdout
-            if mutate_before_store_in_cache is not None:
-                value = mutate_before_store_in_cache(value)
-            if use_cache:
-                self.cache.set(cache_key, value, expire=cache_duration_seconds)
+    >>> github_pr = trim_github_pr_fields({'comments': list(range(50)), 'title': 'Some PR'})
+    >>> github_pr['comments'][0], len(github_pr['comments']), github_pr['title']
+    (20, 30, 'Some PR')
+    >>> comments = [{'author': {'login': 'alice'}, 'body': f'comment {n}'} for n in range(1, 51)]
+    >>> comments[4]['body'] = 'ping @me'  # buried under 45 newer comments
+    >>> github_pr = trim_github_pr_fields({'comments': comments}, 'me')
+    >>> [comment['body'] for comment in github_pr['comments'][:3]], len(github_pr['comments'])
+    (['ping @me', 'comment 21', 'comment 22'], 31)
+    """
 
-        return value
+    if 'comments' in github_pr:
+        older_mentions = [
+            comment
+            for comment in github_pr['comments'][:-MAX_STORED_COMMENTS_AND_COMMITS]
+            if github_user is not None
+            and not is_same_login(comment['author']['login'], github_user)
+            and mentions_user(comment.get('body') or '', github_user)
+        ]
+        github_pr['comments'] = older_mentions[-1:] + github_pr['comments'][-MAX_STORED_COMMENTS_AND_COMMITS:]
+    if 'commits' in github_pr:
+        github_pr['commits'] = github_pr['commits'][-MAX_STORED_COMMENTS_AND_COMMITS:]
+    if 'files' in github_pr:
+        github_pr['files'] = [
+            {'path': file['path'], 'additions': file['additions'], 'deletions': file['deletions']}
+            for file in github_pr['files'][:MAX_STORED_FILES]
+        ]
+    return github_pr
 
-    def _fetch_remaining_github_pr_fields(self, github_pr, use_cache=True):
-        """
-        Since the search API doesn't support all fields, such as `merged`, we fetch those separately.
 
-        This function may be called on `gh search prs` items, but also on database items, such as PRs which are
-        merged/closed by now and therefore aren't returned by our `gh search prs` command lines. It must behave
-        reasonably on database items: those might have outdated information, so we fetch all fields again to ensure
-        everything is up to date with the actual values in GitHub.
-        """
+def is_test_file(path):
+    """
+    >>> [is_test_file(path) for path in ('main_test.go', 'tests/conftest.py', 'src/app.test.ts', 'test_x.py')]
+    [True, True, True, True]
+    >>> [is_test_file(path) for path in ('main.go', 'src/attestation.py', 'contest/x.py')]
+    [False, False, False]
+    """
 
-        updated_seconds_ago = abs(time.time() - github_datetime_to_timestamp(github_pr['updatedAt']))
-        # Cache for longer if last update of PR is long ago
-        if updated_seconds_ago > 86400 * 365:
-            cache_duration_seconds = 14400
-        elif updated_seconds_ago > 86400 * 7:
-            cache_duration_seconds = 3600
-        elif updated_seconds_ago > 86400 * 2:
-            cache_duration_seconds = 1800
-        else:
-            cache_duration_seconds = 600
+    return bool(re.search(r'(^|/)(tests?|__tests__|spec)/|(^|/)test_[^/]*$|[._](test|spec)\.[^/]+$', path))
 
-        extra_fields_json_arg = 'author,closed,state,updatedAt,title'
-        extra_fields = self._cached_subprocess_check_output(
-            cache_key=f'subprocess.pr.{github_pr["url"]}.{extra_fields_json_arg}',
-            cache_duration_seconds=cache_duration_seconds,
-            mutate_before_store_in_cache=lambda v: json.loads(v),
-            use_cache=use_cache and not self.db.get(f'avoid-cache.{github_pr["url"]}'),
-            subprocess_kwargs=dict(
-                args=[
-                    'gh',
-                    'pr',
-                    'view',
-                    github_pr['url'],
-                    '--json', extra_fields_json_arg,
-                ],
-                encoding='utf-8',
-            ),
-        )
 
-        github_pr = copy.deepcopy(github_pr)
-        github_pr.update(extra_fields)
-        return github_pr
+def diff_summary(github_pr):
+    """
+    Short summary to judge the scope of a PR without opening it, or `None` if the changed files weren't fetched.
 
-    def _refetch_and_store_github_pr(self, pr_url):
-        """
-        Refetch PR without reading stale value from cache.
+    >>> diff_summary({'additions': 120, 'deletions': 40, 'changedFiles': 3, 'files': [
+    ...     {'path': 'pkg/a/a.go', 'additions': 20, 'deletions': 10},
+    ...     {'path': 'pkg/a/a_test.go', 'additions': 90, 'deletions': 30},
+    ...     {'path': 'README.md', 'additions': 10, 'deletions': 0},
+    ... ]})
+    '3 files, +120/-40, mostly tests, mainly in pkg/'
+    >>> diff_summary({'additions': 5, 'deletions': 5, 'changedFiles': 250, 'files': [
+    ...     {'path': 'a.py', 'additions': 5, 'deletions': 5}]})
+    '250 files, +5/-5, no tests (first 1 files analyzed)'
+    >>> diff_summary({'additions': 1, 'deletions': 0}) is None  # stored before files were fetched
+    True
+    """
 
-        This only refetches fields requested in `_fetch_remaining_github_pr_fields`, such as `updatedAt`!
-        """
-        with self.db.transact():
-            github_pr = self.db['pull_requests'][pr_url]['github_fields']
-            github_pr = self._fetch_remaining_github_pr_fields(github_pr, use_cache=False)
-            self._update_db_from_github_pr(github_pr)
+    files = github_pr.get('files')
+    if files is None:
+        return None
 
-    def _update_db_from_github_pr(self, github_pr):
-        with self.db.transact():
-            # GitHub PR URL => {'github_fields': {...}, 'workboard_fields': {...}}
-            pull_requests = self.db.get('pull_requests', {})
+    changed_lines_by_dir = {}
+    test_lines = 0
+    total_lines = 0
+    for file in files:
+        lines = file['additions'] + file['deletions']
+        total_lines += lines
+        if is_test_file(file['path']):
+            test_lines += lines
+        top_dir = file['path'].split('/')[0] + '/' if '/' in file['path'] else None
+        if top_dir is not None:
+            changed_lines_by_dir[top_dir] = changed_lines_by_dir.get(top_dir, 0) + lines
 
-            if not pull_requests:
-                logging.debug('Database has no pull requests')
+    file_count = github_pr.get('changedFiles', len(files))
+    parts = [f'{file_count} files', f'+{github_pr["additions"]}/-{github_pr["deletions"]}']
+    if test_lines == 0:
+        parts.append('no tests')
+    elif test_lines * 2 >= total_lines:
+        parts.append('mostly tests')
+    if changed_lines_by_dir:
+        top_dir, top_dir_lines = max(changed_lines_by_dir.items(), key=lambda item: (item[1], item[0]))
+        if top_dir_lines * 2 >= total_lines:
+            parts.append(f'mainly in {top_dir}')
+    summary = ', '.join(parts)
+    if file_count > len(files):
+        summary += f' (first {len(files)} files analyzed)'
+    return summary
 
-            pr = pull_requests.setdefault(github_pr['url'], {})
-            pr['github_fields'] = copy.deepcopy(github_pr)
-            pr.setdefault('workboard_fields', {})
 
-            # These are the only available fields of ours if PR is inserted the first time
-            pr['workboard_fields'].setdefault('status', PullRequestStatus.UNKNOWN)
-            pr['workboard_fields'].setdefault('last_change', github_datetime_to_timestamp(github_pr['updatedAt']))
+def is_bot_login(login):
+    """
+    GitHub Apps have logins ending in `[bot]`. Some bots show up with their plain name in commit authors.
 
-            self._update_status_from_github_pr(pr, github_pr)
+    >>> is_bot_login('dependabot[bot]'), is_bot_login('github-actions'), is_bot_login('dependabot')
+    (True, True, True)
+    >>> is_bot_login('alice'), is_bot_login('robot')
+    (False, False)
+    """
 
-            if (pr['workboard_fields']['status'] == PullRequestStatus.DELETED
-                    and pr['workboard_fields']['delete_after'] <= time.time()):
-                logging.info('Deleting PR %r from database', github_pr['url'])
-                del pull_requests[github_pr['url']]
+    return login.endswith('[bot]') or login in ('github-actions', 'dependabot')
 
-            self._validate_pull_requests(pull_requests)
-            self.db.set('pull_requests', pull_requests)
 
-    def _update_status_from_github_pr(self, pr, github_pr):
-        # See GitHub PR fields https://docs.github.com/en/graphql/reference/objects#pullrequest.
-        # If any new fields are required here, add them to our `gh search prs [...] --json` command or it won't
-        # be fetched.
+def is_same_login(login, other_login):
+    """
+    GitHub logins are case-insensitive. Team review requests have no login, which never matches.
 
-        # Migrations from renames/refactoring
-        if (pr['workboard_fields']['status'] == 'snoozed'
-                and pr['workboard_fields'].get('snooze_until_updated_at_changed_from')):
-            logging.info('Migrating `snoozed` status value for PR %r', github_pr['url'])
-            pr['workboard_fields']['status'] = PullRequestStatus.SNOOZED_UNTIL_UPDATE
+    >>> is_same_login('AndiDog', 'andidog')
+    True
+    >>> is_same_login('alice', 'alice2'), is_same_login(None, 'alice')
+    (False, False)
+    """
 
-        if (pr['workboard_fields']['status'] not in (PullRequestStatus.DELETED, PullRequestStatus.MERGED)
-                and github_pr['state'].lower() == 'merged'
-                and github_pr['closed']):
-            if pr['workboard_fields']['status'] == PullRequestStatus.REVIEWED_DELETE_ON_MERGE:
-                logging.info('Marking PR %r as deleted because it was merged', github_pr['url'])
-                pr['workboard_fields']['status'] = PullRequestStatus.DELETED
-                pr['workboard_fields']['last_change'] = time.time()
-                pr['workboard_fields']['delete_after'] = time.time() + 86400 * 30
-            else:
-                logging.info('Marking PR %r as merged', github_pr['url'])
-                pr['workboard_fields']['status'] = PullRequestStatus.MERGED
-                pr['workboard_fields']['last_change'] = time.time()
+    return login is not None and other_login is not None and login.lower() == other_login.lower()
 
-        if (pr['workboard_fields']['status'] == PullRequestStatus.REVIEWED_DELETE_ON_MERGE
-                and pr['workboard_fields']['bring_back_to_review_if_not_merged_until'] <= time.time()):
-            logging.info('Passed the time until PR %r was meant to be merged, marking as must-review', github_pr['url'])
-            pr['workboard_fields']['status'] = PullRequestStatus.MUST_REVIEW
-            pr['workboard_fields']['last_change'] = time.time()
-            del pr['workboard_fields']['bring_back_to_review_if_not_merged_until']
 
-        if (pr['workboard_fields']['status'] not in (PullRequestStatus.DELETED, PullRequestStatus.CLOSED)
-                and github_pr['state'].lower() == 'closed'
-                and github_pr['closed']):
-            pr['workboard_fields']['status'] = PullRequestStatus.CLOSED
-            pr['workboard_fields']['last_change'] = time.time()
+def last_activity_actor(github_pr):
+    """
+    Who touched the PR last (comment, review or commit), or `None` if unknown.
 
-        if (pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_TIME
-                and pr['workboard_fields']['snooze_until'] <= time.time()):
-            logging.info('Passed the time until PR %r was snoozed, unsnoozing it', github_pr['url'])
-            pr['workboard_fields']['status'] = PullRequestStatus.MUST_REVIEW
-            pr['workboard_fields']['last_change'] = time.time()
-            del pr['workboard_fields']['snooze_until']
+    >>> last_activity_actor({
+    ...     'comments': [{'author': {'login': 'alice'}, 'createdAt': '2024-01-02T00:00:00Z'}],
+    ...     'reviews': [{'author': {'login': 'bob'}, 'submittedAt': '2024-01-03T00:00:00Z'}],
+    ...     'commits': [{'authors': [{'login': 'carol'}], 'committedDate': '2024-01-01T00:00:00Z'}],
+    ... })
+    {'login': 'bob', 'is_bot': False}
+    >>> last_activity_actor({
+    ...     'commits': [{'authors': [{'login': 'dependabot[bot]'}], 'committedDate': '2024-01-01T00:00:00Z'}],
+    ... })
+    {'login': 'dependabot[bot]', 'is_bot': True}
+    >>> last_activity_actor({'commits': [{'authors': [{'login': ''}], 'committedDate': '2024-01-01T00:00:00Z'}]})
+    >>> last_activity_actor({}) is None  # database item from before these fields were fetched
+    True
+    """
 
-        if (pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_UPDATE
-                and github_pr.get('updatedAt')
-                and github_pr['updatedAt'] != pr['workboard_fields']['snooze_until_updated_at_changed_from']):
-            logging.info(
-                'Snoozed PR %r was updated between %r and %r, unsnoozing it',
-                github_pr['url'], pr['workboard_fields']['snooze_until_updated_at_changed_from'], github_pr['updatedAt'])
-            pr['workboard_fields']['status'] = PullRequestStatus.UPDATED_AFTER_SNOOZE
-            pr['workboard_fields']['last_change'] = time.time()
-            del pr['workboard_fields']['snooze_until_updated_at_changed_from']
+    events = []
+    for comment in github_pr.get('comments', []):
+        events.append((comment['createdAt'], comment['author']['login']))
+    for review in github_pr.get('reviews', []):
+        if review.get('submittedAt'):
+            events.append((review['submittedAt'], review['author']['login']))
+    for commit in github_pr.get('commits', []):
+        # Commits by authors without GitHub account have an empty login
+        if commit['authors'] and commit['authors'][0]['login']:
+            events.append((commit['committedDate'], commit['authors'][0]['login']))
 
-    @staticmethod
-    def _validate_pull_requests(pull_requests):
-        # Some checks for logic errors (important until we use static typing checks)
-        for url, pr in pull_requests.items():
-            assert url.startswith('http')
-            # `render_only_fields` not wanted in storage
-            unwanted_fields = set(pr.keys()) - {'github_fields', 'workboard_fields'}
-            assert not unwanted_fields, f'Unwanted fields in PR object: {unwanted_fields}'
+    if not events:
+        return None
+    login = max(events, key=lambda event: github_datetime_to_timestamp(event[0]))[1]
+    return {
+        'login': login,
+        'is_bot': is_bot_login(login),
+    }
 
-    def do_GET(self):
-        if self.path == '/favicon.ico':
-            self.send_response(404)
-            self.end_headers()
-            return
 
-        if self.path != '/':
-            raise RuntimeError(f'This app has only URL path `/` (not {self.path!r})')
+def is_unread(github_pr, workboard_fields, github_user):
+    """
+    Unread means the user never opened the PR from the board, someone else commented, reviewed or pushed since, or the
+    user marked it as unread. Own activity doesn't count.
 
-        try:
-            already_updated_github_pr_urls = set()
+    >>> github_pr = {
+    ...     'comments': [{'author': {'login': 'alice'}, 'createdAt': '2024-01-02T00:00:00Z'}],
+    ...     'reviews': [{'author': {'login': 'me'}, 'submittedAt': '2024-01-03T00:00:00Z'}],
+    ...     'commits': [{'authors': [{'login': 'alice'}], 'committedDate': '2024-01-01T00:00:00Z'}],
+    ... }
+    >>> jan_2 = github_datetime_to_timestamp('2024-01-02T00:00:00Z')
+    >>> is_unread(github_pr, {}, 'me')  # never opened
+    True
+    >>> is_unread(github_pr, {'last_visited_at': jan_2 - 1}, 'me')
+    True
+    >>> is_unread(github_pr, {'last_visited_at': jan_2}, 'me')  # own review afterwards doesn't count
+    False
+    >>> is_unread(github_pr, {'last_visited_at': jan_2, 'marked_unread': True}, 'me')
+    True
+    >>> is_unread({}, {'last_visited_at': jan_2}, 'me')  # database item from before these fields were fetched
+    False
+    """
 
-            pr_search_json_fields_arg = 'author,repository,state,updatedAt,url,title'
+    if workboard_fields.get('marked_unread'):
+        return True
+    last_visited_at = workboard_fields.get('last_visited_at')
+    if last_visited_at is None:
+        return True
 
-            for desc, cache_key, subprocess_kwargs in (
-                (
-                    'Own PRs',
-                    f'subprocess.prs.own.{self.github_user}.{pr_search_json_fields_arg}',
-                    dict(
-                        args=[
-                            'gh',
-                            'search', 'prs',
-                            '--author', self.github_user,
-                            '--state', 'open',
-                            '--json', pr_search_json_fields_arg
-                        ],
-                        encoding='utf-8',
-                    ),
-                ),
-                (
-                    'Assigned PRs',
-                    f'subprocess.prs.assigned.{self.github_user}.{pr_search_json_fields_arg}',
-                    dict(
-                        args=[
-                            'gh',
-                            'search', 'prs',
-                            '--assignee', self.github_user,
-                            '--state', 'open',
-                            '--json', pr_search_json_fields_arg
-                        ],
-                        encoding='utf-8',
-                    ),
-                ),
-                (
-                    'Review requested PRs',
-                    f'subprocess.prs.review-requested.{self.github_user}.{pr_search_json_fields_arg}',
-                    dict(
-                        args=[
-                            'gh',
-                            'search', 'prs',
-                            '--review-requested', self.github_user,
-                            '--state', 'open',
-                            '--json', pr_search_json_fields_arg
-                        ],
-                        encoding='utf-8',
-                    ),
-                ),
-                (
-                    'Reviewed by me PRs',
-                    f'subprocess.prs.reviewed-by-me.{self.github_user}.{pr_search_json_fields_arg}',
-                    dict(
-                        args=[
-                            'gh',
-                            'search', 'prs',
-                            '--reviewed-by', self.github_user,
-                            '--state', 'open',
-                            '--json', pr_search_json_fields_arg
-                        ],
-                        encoding='utf-8',
-                    ),
-                ),
-            ):
-                for github_pr in timed(desc, lambda: self._cached_subprocess_check_output(
-                    cache_key=cache_key,
-                    cache_duration_seconds=600,
-                    mutate_before_store_in_cache=lambda v: json.loads(v),
-                    subprocess_kwargs=subprocess_kwargs,
-                )):
-                    if github_pr['url'] in already_updated_github_pr_urls:
-                        continue
-                    github_pr = self._fetch_remaining_github_pr_fields(github_pr)
-                    self._update_db_from_github_pr(github_pr)
-                    already_updated_github_pr_urls.add(github_pr['url'])
+    activity_times = []
+    for comment in github_pr.get('comments', []):
+        if not is_same_login(comment['author']['login'], github_user):
+            activity_times.append(comment['createdAt'])
+    for review in github_pr.get('reviews', []):
+        if not is_same_login(review['author']['login'], github_user) and review.get('submittedAt'):
+            activity_times.append(review['submittedAt'])
+    for commit in github_pr.get('commits', []):
+        if commit['authors'] and not is_same_login(commit['authors'][0]['login'], github_user):
+            activity_times.append(commit['committedDate'])
+    return any(github_datetime_to_timestamp(activity_time) > last_visited_at for activity_time in activity_times)
 
-            pull_requests_from_db = self.db.get('pull_requests', {})
-            missing_github_pr_urls = set(pull_requests_from_db.keys()) - already_updated_github_pr_urls
-            # Only sorted to get the same behavior every time
-            for github_pr in map(lambda pr_url: pull_requests_from_db[pr_url]['github_fields'], sorted(missing_github_pr_urls)):
-                # PR could be closed/merged or otherwise not contained in the above queries. Since it's already in the
-                # database, the user is interested in seeing updates, so we treat it like all others, of course.
-                assert github_pr['url'] not in already_updated_github_pr_urls  # we loop through `missing_github_pr_urls`
-                github_pr = self._fetch_remaining_github_pr_fields(github_pr)
-                self._update_db_from_github_pr(github_pr)
-                already_updated_github_pr_urls.add(github_pr['url'])
 
-            pull_requests_to_render = sorted(
-                map(
-                    self._add_render_only_fields,
-                    filter(
-                        lambda pr: pr['workboard_fields']['status'] != PullRequestStatus.DELETED,
-                        pull_requests_from_db.values(),
-                    ),
-                ),
-                # PRs with latest changes are displayed on top, ordered by status.
-                key=lambda pr: (
-                    PR_STATUS_SORT_ORDER[pr['workboard_fields']['status']],
-                    -github_datetime_to_timestamp(pr['github_fields']['updatedAt']),
-                    -pr['workboard_fields'].get('last_change', 2**63),
-                ),
-            )
+def own_comment_awaiting_response_since(github_pr, github_user):
+    """
+    If the user's comment (or commenting review) is the latest human activity on the PR, returns its time, since
+    nobody responded yet.
+
+    >>> github_pr = {
+    ...     'comments': [
+    ...         {'author': {'login': 'alice'}, 'createdAt': '2024-01-01T00:00:00Z'},
+    ...         {'author': {'login': 'me'}, 'createdAt': '2024-01-02T00:00:00Z'},
+    ...         {'author': {'login': 'github-actions'}, 'createdAt': '2024-01-03T00:00:00Z'},
+    ...     ],
+    ...     'reviews': [{'author': {'login': 'me'}, 'state': 'APPROVED', 'submittedAt': '2024-01-01T12:00:00Z'}],
+    ... }
+    >>> own_comment_awaiting_response_since(github_pr, 'me')  # bot comments are no response
+    1704153600
+    >>> github_pr['commits'] = [{'authors': [{'login': 'alice'}], 'committedDate': '2024-01-04T00:00:00Z'}]
+    >>> own_comment_awaiting_response_since(github_pr, 'me') is None  # author pushed changes in response
+    True
+    >>> own_comment_awaiting_response_since({
+    ...     'reviews': [{'author': {'login': 'me'}, 'state': 'APPROVED', 'submittedAt': '2024-01-01T12:00:00Z'}],
+    ... }, 'me') is None  # nothing to respond to
+    True
+    """
+
+    events = []
+    for comment in github_pr.get('comments', []):
+        events.append((comment['createdAt'], comment['author']['login'], True))
+    for review in github_pr.get('reviews', []):
+        if review.get('submittedAt'):
+            expects_response = review['state'] in ('COMMENTED', 'CHANGES_REQUESTED')
+            events.append((review['submittedAt'], review['author']['login'], expects_response))
+    for commit in github_pr.get('commits', []):
+        if commit['authors'] and commit['authors'][0]['login']:
+            events.append((commit['committedDate'], commit['authors'][0]['login'], False))
+
+    human_events = [event for event in events if not is_bot_login(event[1])]
+    if not human_events:
+        return None
+    created_at, login, expects_response = max(
+        human_events, key=lambda event: github_datetime_to_timestamp(event[0]))
+    if not is_same_login(login, github_user) or not expects_response:
+        return None
+    return github_datetime_to_timestamp(created_at)
+
+
+def is_smaller_than(github_pr, max_changed_lines):
+    """
+    >>> is_smaller_than({'additions': 400, 'deletions': 100}, 500)
+    False
+    >>> is_smaller_than({'additions': 300, 'deletions': 100}, 500)
+    True
+    >>> is_smaller_than({}, 500)  # size unknown
+    False
+    """
+
+    if 'additions' not in github_pr or 'deletions' not in github_pr:
+        return False
+    return github_pr['additions'] + github_pr['deletions'] < max_changed_lines
+
+
+def workflow_conclusion(checks, workflow_name):
+    """
+    State of the named workflow among the PR's checks (`statusCheckRollup` field): `None` if it doesn't exist,
+    `pending` while any of its jobs still runs, otherwise the conclusion (lowercase, e.g. `success` or `failure`, the
+    worst one if jobs differ).
+
+    GitHub Actions jobs are matched by workflow or job name, commit statuses of other CI systems by their context.
+
+    >>> checks = [
+    ...     {'__typename': 'CheckRun', 'name': 'build', 'workflowName': 'Deploy', 'status': 'COMPLETED',
+    ...      'conclusion': 'SUCCESS'},
+    ...     {'__typename': 'CheckRun', 'name': 'rollout', 'workflowName': 'Deploy', 'status': 'IN_PROGRESS',
+    ...      'conclusion': ''},
+    ...     {'__typename': 'CheckRun', 'name': 'lint', 'workflowName': 'CI', 'status': 'COMPLETED',
+    ...      'conclusion': 'FAILURE'},
+    ...     {'__typename': 'StatusContext', 'context': 'ci/jenkins', 'state': 'PENDING'},
+    ... ]
+    >>> workflow_conclusion(checks, 'Deploy')
+    'pending'
+    >>> checks[1].update(status='COMPLETED', conclusion='FAILURE')
+    >>> workflow_conclusion(checks, 'Deploy')
+    'failure'
+    >>> workflow_conclusion(checks, 'lint')
+    'failure'
+    >>> workflow_conclusion(checks, 'ci/jenkins')
+    'pending'
+    >>> checks[3]['state'] = 'SUCCESS'
+    >>> workflow_conclusion(checks, 'ci/jenkins')
+    'success'
+    >>> print(workflow_conclusion(checks, 'Release'))
+    None
+    """
+
+    conclusions = []
+    for check in checks:
+        if check['__typename'] == 'StatusContext':
+            if check['context'] == workflow_name:
+                conclusions.append('pending' if check['state'] in ('EXPECTED', 'PENDING') else check['state'].lower())
+        elif workflow_name in (check.get('workflowName'), check['name']):
+            conclusions.append(check['conclusion'].lower() if check['status'] == 'COMPLETED' else 'pending')
+
+    if not conclusions:
+        return None
+    if 'pending' in conclusions:
+        return 'pending'
+    successful = [conclusion for conclusion in conclusions if conclusion in ('success', 'neutral', 'skipped')]
+    return next((conclusion for conclusion in conclusions if conclusion not in successful), conclusions[0])
+
+
+def creates_snooze_cycle(pull_requests, pr_url, other_pr_url):
+    """
+    Whether snoozing a PR until another one is merged would make PRs wait for each other forever.
+
+    >>> pull_requests = {
+    ...     'https://github.com/a/b/pull/2': {'workboard_fields': {
+    ...         'status': 'snoozed-until-other-merged', 'snooze_until_merged_pr_url': 'https://github.com/a/b/pull/1'}},
+    ...     'https://github.com/a/b/pull/3': {'workboard_fields': {
+    ...         'status': 'snoozed-until-other-merged', 'snooze_until_merged_pr_url': 'https://github.com/a/b/pull/2'}},
+    ... }
+    >>> creates_snooze_cycle(pull_requests, 'https://github.com/a/b/pull/1', 'https://github.com/a/b/pull/3')
+    True
+    >>> creates_snooze_cycle(pull_requests, 'https://github.com/a/b/pull/4', 'https://github.com/a/b/pull/3')
+    False
+    >>> creates_snooze_cycle(pull_requests, 'https://github.com/a/b/pull/4', 'https://github.com/a/b/pull/4')
+    True
+    """
+
+    seen_pr_urls = set()
+    while other_pr_url not in seen_pr_urls:
+        if other_pr_url == pr_url:
+            return True
+        seen_pr_urls.add(other_pr_url)
+        other_pr = pull_requests.get(other_pr_url)
+        if other_pr is None or other_pr['workboard_fields']['status'] != PullRequestStatus.SNOOZED_UNTIL_OTHER_MERGED:
+            return False
+        other_pr_url = other_pr['workboard_fields']['snooze_until_merged_pr_url']
+    return False
+
+
+def has_no_reviewers(github_pr):
+    """
+    Whether an open PR requires review, but nobody is requested to review it and nobody reviewed it yet.
+
+    PRs without a review requirement (empty `reviewDecision`) never count as lacking reviewers.
+
+    >>> has_no_reviewers({'state': 'OPEN', 'reviewDecision': 'REVIEW_REQUIRED', 'reviewRequests': [], 'reviews': []})
+    True
+    >>> has_no_reviewers({'state': 'OPEN', 'reviewDecision': 'REVIEW_REQUIRED',
+    ...                   'reviewRequests': [{'login': 'someone'}], 'reviews': []})
+    False
+    >>> has_no_reviewers({'state': 'OPEN', 'reviewDecision': 'REVIEW_REQUIRED',
+    ...                   'reviewRequests': [], 'reviews': [{'author': {'login': 'someone'}, 'state': 'COMMENTED'}]})
+    False
+    >>> has_no_reviewers({'state': 'OPEN', 'reviewDecision': '', 'reviewRequests': [], 'reviews': []})
+    False
+    >>> has_no_reviewers({'state': 'MERGED', 'reviewDecision': 'REVIEW_REQUIRED', 'reviewRequests': [], 'reviews': []})
+    False
+    >>> has_no_reviewers({'state': 'OPEN'})  # database item from before these fields were fetched
+    False
+    """
+
+    return (github_pr['state'].lower() == 'open'
+            and github_pr.get('reviewDecision') == 'REVIEW_REQUIRED'
+            and not github_pr.get('reviewRequests')
+            and not github_pr.get('reviews'))
+
+
+def validate_external_url(url):
+    """
+    Returns the stripped URL, or `None` if empty (meaning the URL should be cleared).
+
+    >>> validate_external_url('  https://jira.example.com/browse/ABC-123 ')
+    'https://jira.example.com/browse/ABC-123'
+    >>> validate_external_url('') is None
+    True
+    >>> validate_external_url('jira.example.com/browse/ABC-123')
+    Traceback (most recent call last):
+    ...
+    ValueError: External URL must be an absolute http(s) URL
+    >>> validate_external_url('javascript:alert(1)')
+    Traceback (most recent call last):
+    ...
+    ValueError: External URL must be an absolute http(s) URL
+    """
+
+    url = url.strip()
+    if not url:
+        return None
+    if len(url) > 1000:
+        raise ValueError('External URL is too long')
+    parts = urlsplit(url)
+    if parts.scheme not in ('http', 'https') or not parts.netloc:
+        raise ValueError('External URL must be an absolute http(s) URL')
+    return url
+
+
+def parse_pull_request_url(url, github_host=None):
+    """
+    Canonical PR URL and repository (in the format of `gh search prs` results) for a PR URL pasted by the user. Links
+    to a PR's tabs, comments or commits are fine.
+
+    >>> parse_pull_request_url('https://github.com/org/repo/pull/12/files#diff-123')
+    ('https://github.com/org/repo/pull/12', {'nameWithOwner': 'org/repo', 'name': 'repo'})
+    >>> parse_pull_request_url(' github.example.com/org/repo/pull/3 ', 'github.example.com')[0]
+    'https://github.example.com/org/repo/pull/3'
+    >>> parse_pull_request_url('https://github.com/org/repo/issues/12')
+    Traceback (most recent call last):
+    ...
+    ValueError: Not a GitHub PR URL: 'https://github.com/org/repo/issues/12'
+    >>> parse_pull_request_url('https://github.com/org/repo/pull/12', 'github.example.com')
+    Traceback (most recent call last):
+    ...
+    ValueError: Not a GitHub PR URL: 'https://github.com/org/repo/pull/12'
+    """
+
+    host = github_host if github_host is not None else 'github.com'
+    url = url.strip()
+    m = re.fullmatch(
+        rf'(?:https://)?{re.escape(host)}/([\w.-]{{1,100}})/([\w.-]{{1,100}})/pull/([0-9]{{1,10}})(?:[/?#].*)?',
+        url,
+        re.IGNORECASE)
+    if m is None:
+        raise ValueError(f'Not a GitHub PR URL: {url!r}')
+    owner, repo_name, number = m.groups()
+    repository = {'nameWithOwner': f'{owner}/{repo_name}', 'name': repo_name}
+    return f'https://{host}/{owner}/{repo_name}/pull/{number}', repository
+
+
+def delegate_has_reviewed(github_pr, delegate_login, since_timestamp):
+    """
+    >>> github_pr = {'reviews': [
+    ...     {'author': {'login': 'Bob'}, 'state': 'APPROVED', 'submittedAt': '2024-01-02T00:00:00Z'}]}
+    >>> delegate_has_reviewed(github_pr, 'bob', since_timestamp=1704067200)  # 2024-01-01
+    True
+    >>> delegate_has_reviewed(github_pr, 'bob', since_timestamp=1704240000)  # 2024-01-03
+    False
+    >>> delegate_has_reviewed(github_pr, 'alice', since_timestamp=1704067200)
+    False
+    >>> delegate_has_reviewed({}, 'bob', since_timestamp=1704067200)
+    False
+    """
+
+    return any(
+        is_same_login(review['author']['login'], delegate_login)
+        and review.get('submittedAt')
+        and github_datetime_to_timestamp(review['submittedAt']) >= since_timestamp
+        for review in github_pr.get('reviews', []))
+
+
+def latest_own_review(github_pr, github_user):
+    """
+    >>> github_pr = {'reviews': [
+    ...     {'author': {'login': 'me'}, 'state': 'APPROVED', 'submittedAt': '2024-01-02T00:00:00Z'},
+    ...     {'author': {'login': 'me'}, 'state': 'COMMENTED', 'submittedAt': '2024-01-01T00:00:00Z'},
+    ...     {'author': {'login': 'other'}, 'state': 'COMMENTED', 'submittedAt': '2024-01-03T00:00:00Z'},
+    ... ]}
+    >>> latest_own_review(github_pr, 'me')['state']
+    'APPROVED'
+    >>> latest_own_review(github_pr, 'ME')['state']
+    'APPROVED'
+    >>> latest_own_review(github_pr, 'someone') is None
+    True
+    """
+
+    return max(
+        (
+            review
+            for review in github_pr.get('reviews', [])
+            if is_same_login(review['author']['login'], github_user) and review.get('submittedAt')
+        ),
+        key=lambda review: github_datetime_to_timestamp(review['submittedAt']),
+        default=None)
+
+
+def mentions_user(text, github_user):
+    """
+    Like GitHub, only whole logins count. Team mentions (`@org/team`) don't count, even if the org or team is named
+    like the user.
+
+    >>> [mentions_user(text, 'me') for text in ('cc @Me', '@me, wdyt?', '(@me)', '@me.', '@me/')]
+    [True, True, True, True, True]
+    >>> [mentions_user(text, 'me') for text in ('ask @me-team', '@meh', '@me_', 'me@me.com', '@org/me', '@me/team')]
+    [False, False, False, False, False, False]
+    """
+
+    pattern = rf'(?<![\w@/-])@{re.escape(github_user)}(?![\w-]|/[\w-])'
+    return re.search(pattern, text, re.IGNORECASE) is not None
+
+
+def review_thread_comments(graphql_response):
+    """
+    Inline code comments from a `reviewThreads` GraphQL query, oldest first, limited to the latest ones.
+
+    >>> comments = review_thread_comments({'data': {'repository': {'pullRequest': {'reviewThreads': {'nodes': [
+    ...     {'comments': {'nodes': [
+    ...         {'author': {'login': 'alice'}, 'body': 'nit', 'createdAt': '2024-01-03T00:00:00Z'}]}},
+    ...     {'comments': {'nodes': [
+    ...         {'author': None, 'body': '@me?', 'createdAt': '2024-01-01T00:00:00Z'}]}},
+    ... ]}}}}})
+    >>> [(comment['author']['login'], comment['body']) for comment in comments]
+    [('ghost', '@me?'), ('alice', 'nit')]
+    """
+
+    comments = [
+        # Deleted accounts have no author
+        dict(comment, author=comment['author'] or {'login': 'ghost'})
+        for thread in graphql_response['data']['repository']['pullRequest']['reviewThreads']['nodes']
+        for comment in thread['comments']['nodes']
+    ]
+    comments.sort(key=lambda comment: github_datetime_to_timestamp(comment['createdAt']))
+    return comments[-MAX_STORED_REVIEW_THREAD_COMMENTS:]
+
+
+def latest_mention_at(github_pr, github_user):
+    """
+    Time (GitHub format) of the latest comment, inline code comment or review by someone else which mentions the user,
+    or `None`.
+
+    >>> github_pr = {
+    ...     'comments': [
+    ...         {'author': {'login': 'alice'}, 'body': 'cc @Me', 'createdAt': '2024-01-01T00:00:00Z'},
+    ...         {'author': {'login': 'bob'}, 'body': 'ask @me-team or @meh', 'createdAt': '2024-01-03T00:00:00Z'},
+    ...         {'author': {'login': 'me'}, 'body': 'note to @me', 'createdAt': '2024-01-04T00:00:00Z'},
+    ...     ],
+    ...     'reviews': [{'author': {'login': 'bob'}, 'body': '@me wdyt?', 'submittedAt': '2024-01-02T00:00:00Z'}],
+    ... }
+    >>> latest_mention_at(github_pr, 'me')
+    '2024-01-02T00:00:00Z'
+    >>> print(latest_mention_at(github_pr, 'carol'))
+    None
+    >>> github_pr['reviewThreadComments'] = [
+    ...     {'author': {'login': 'carol'}, 'body': 'Is this right, @me?', 'createdAt': '2024-01-05T00:00:00Z'}]
+    >>> latest_mention_at(github_pr, 'me')
+    '2024-01-05T00:00:00Z'
+    """
+
+    mentioned_at = [
+        entry.get('createdAt') or entry.get('submittedAt')
+        for entry in (
+            github_pr.get('comments', []) + github_pr.get('reviews', []) + github_pr.get('reviewThreadComments', []))
+        if not is_same_login(entry['author']['login'], github_user)
+        and mentions_user(entry.get('body') or '', github_user)
+    ]
+    return max(
+        (timestamp for timestamp in mentioned_at if timestamp),
+        key=github_datetime_to_timestamp,
+        default=None)
+
+
+def is_new_mention(mentioned_at, workboard_fields, lookback_seconds, now):
+    """
+    Whether the latest mention (see `latest_mention_at`) should be reacted to. For PRs already on the board, any
+    mention we haven't seen yet counts, however old. The first time we see a PR, only mentions from the look-back
+    window count, so that importing old PRs doesn't fire for mentions which are old news.
+
+    >>> jan_2 = github_datetime_to_timestamp('2024-01-02T00:00:00Z')
+    >>> is_new_mention('2024-01-01T00:00:00Z', {'last_mention_at': None}, 0, now=jan_2)  # refresh
+    True
+    >>> is_new_mention('2024-01-01T00:00:00Z', {'last_mention_at': '2024-01-01T00:00:00Z'}, 0, now=jan_2)
+    False
+    >>> is_new_mention('2024-01-01T00:00:00Z', {}, 86400, now=jan_2)  # import, right at the window boundary
+    True
+    >>> is_new_mention('2024-01-01T00:00:00Z', {}, 86399, now=jan_2)
+    False
+    >>> is_new_mention(None, {}, 86400, now=jan_2)
+    False
+    """
+
+    if mentioned_at is None:
+        return False
+    if 'last_mention_at' in workboard_fields:
+        return mentioned_at != workboard_fields['last_mention_at']
+    return now - github_datetime_to_timestamp(mentioned_at) <= lookback_seconds
+
+
+def requested_reviewers(github_pr):
+    """
+    Who is still requested to review, users as `@login` and teams by their slug (or name).
+
+    >>> requested_reviewers({'reviewRequests': [
+    ...     {'__typename': 'User', 'login': 'alice'},
+    ...     {'__typename': 'Team', 'name': 'Platform Team', 'slug': 'platform-team'},
+    ...     {'__typename': 'Team', 'name': 'Old Team'},
+    ... ]})
+    ['@alice', 'team platform-team', 'team Old Team']
+    >>> requested_reviewers({})
+    []
+    """
+
+    reviewers = []
+    for request in github_pr.get('reviewRequests', []):
+        if request.get('login'):
+            reviewers.append(f'@{request["login"]}')
+        elif request.get('slug') or request.get('name'):
+            reviewers.append(f'team {request.get("slug") or request["name"]}')
+    return reviewers
+
+
+def has_merge_conflicts(github_pr):
+    """
+    GitHub computes mergeability lazily and reports `UNKNOWN` meanwhile, which must not count as conflict.
+
+    >>> has_merge_conflicts({'mergeable': 'CONFLICTING', 'mergeStateStatus': 'DIRTY'})
+    True
+    >>> has_merge_conflicts({'mergeable': 'MERGEABLE', 'mergeStateStatus': 'BLOCKED'})
+    False
+    >>> has_merge_conflicts({'mergeable': 'UNKNOWN', 'mergeStateStatus': 'UNKNOWN'})
+    False
+    >>> has_merge_conflicts({})  # stored before the field was fetched
+    False
+    """
+
+    return github_pr.get('mergeable') == 'CONFLICTING'
+
+
+def merge_state_desc(github_pr):
+    """
+    Short explanation why a conflict-free PR can't be merged yet, or `None` if there's nothing noteworthy (or GitHub
+    didn't compute it yet).
+
+    >>> merge_state_desc({'mergeable': 'MERGEABLE', 'mergeStateStatus': 'BEHIND'})
+    'branch out of date'
+    >>> merge_state_desc({'mergeable': 'MERGEABLE', 'mergeStateStatus': 'UNSTABLE'})
+    'failing checks'
+    >>> print(merge_state_desc({'mergeable': 'MERGEABLE', 'mergeStateStatus': 'CLEAN'}))
+    None
+    >>> print(merge_state_desc({'mergeable': 'CONFLICTING', 'mergeStateStatus': 'DIRTY'}))  # shown as conflict
+    None
+    >>> print(merge_state_desc({}))
+    None
+    """
+
+    if github_pr.get('mergeable') != 'MERGEABLE':
+        return None
+    return {
+        'BEHIND': 'branch out of date',
+        'UNSTABLE': 'failing checks',
+    }.get(github_pr.get('mergeStateStatus'))
+
+
+def review_decision(github_pr):
+    """
+    GitHub's overall review decision, based on branch protection rules. `None` if the repo doesn't require reviews, and
+    for PRs stored before the field was fetched.
+
+    >>> review_decision({'reviewDecision': 'APPROVED'})
+    'approved'
+    >>> review_decision({'reviewDecision': 'CHANGES_REQUESTED'})
+    'changes-requested'
+    >>> review_decision({'reviewDecision': 'REVIEW_REQUIRED'})
+    'review-required'
+    >>> print(review_decision({'reviewDecision': ''}))
+    None
+    >>> print(review_decision({}))
+    None
+    """
+
+    decision = github_pr.get('reviewDecision')
+    if decision not in ('APPROVED', 'CHANGES_REQUESTED', 'REVIEW_REQUIRED'):
+        return None
+    return decision.lower().replace('_', '-')
+
+
+def own_review_state(github_pr, github_user):
+    """
+    Distinguishes "awaiting your review" (`requested`) from what the user's latest review said. `None` if the user
+    neither reviewed nor is requested.
+
+    >>> review = lambda state: {'author': {'login': 'me'}, 'state': state, 'submittedAt': '2024-01-01T00:00:00Z'}
+    >>> own_review_state({'reviewRequests': [{'login': 'me'}], 'reviews': [review('APPROVED')]}, 'me')  # re-requested
+    'requested'
+    >>> own_review_state({'reviewRequests': [], 'reviews': [review('APPROVED')]}, 'me')
+    'approved'
+    >>> own_review_state({'reviewRequests': [], 'reviews': [review('CHANGES_REQUESTED')]}, 'me')
+    'changes-requested'
+    >>> print(own_review_state({'reviewRequests': [{'name': 'some-team'}], 'reviews': []}, 'me'))
+    None
+    >>> print(own_review_state({}, 'me'))
+    None
+    """
+
+    if any(is_same_login(request.get('login'), github_user) for request in github_pr.get('reviewRequests', [])):
+        return 'requested'
+    review = latest_own_review(github_pr, github_user)
+    if review is None:
+        return None
+    return review['state'].lower().replace('_', '-')
+
+
+def important_reviewer_states(github_pr, important_reviewers):
+    """
+    Review state of each configured "reviewer I care about" who is involved in the PR, in configuration order.
+
+    Like GitHub's review decision, only approvals and change requests count, and re-requesting a review resets it to
+    pending.
+
+    >>> github_pr = {
+    ...     'reviewRequests': [{'login': 'carol'}],
+    ...     'reviews': [
+    ...         {'author': {'login': 'Alice'}, 'state': 'CHANGES_REQUESTED', 'submittedAt': '2024-01-01T00:00:00Z'},
+    ...         {'author': {'login': 'Alice'}, 'state': 'APPROVED', 'submittedAt': '2024-01-02T00:00:00Z'},
+    ...         {'author': {'login': 'alice'}, 'state': 'COMMENTED', 'submittedAt': '2024-01-03T00:00:00Z'},
+    ...         {'author': {'login': 'bob'}, 'state': 'COMMENTED', 'submittedAt': '2024-01-01T00:00:00Z'},
+    ...         {'author': {'login': 'carol'}, 'state': 'APPROVED', 'submittedAt': '2024-01-01T00:00:00Z'},
+    ...     ],
+    ... }
+    >>> important_reviewer_states(github_pr, ['carol', 'alice', 'bob', 'dave'])
+    [('carol', 'pending'), ('alice', 'approved'), ('bob', 'commented')]
+    >>> important_reviewer_states({}, ['alice'])
+    []
+    """
+
+    states = []
+    for login in important_reviewers:
+        reviews = sorted(
+            (
+                review
+                for review in github_pr.get('reviews', [])
+                if is_same_login(review['author']['login'], login) and review.get('submittedAt')
+            ),
+            key=lambda review: github_datetime_to_timestamp(review['submittedAt']))
+        decisive_states = [
+            review['state'] for review in reviews if review['state'] in ('APPROVED', 'CHANGES_REQUESTED', 'DISMISSED')]
+
+        is_requested = any(
+            is_same_login(request.get('login'), login) for request in github_pr.get('reviewRequests', []))
+        if is_requested:
+            states.append((login, 'pending'))
+        elif decisive_states and decisive_states[-1] != 'DISMISSED':
+            states.append((login, decisive_states[-1].lower().replace('_', '-')))
+        elif reviews:
+            states.append((login, 'commented'))
+    return states
+
+
+def closed_without_own_review(github_pr, github_user):
+    """
+    PR of someone else which got closed (not merged) while the user's review was still requested, so there's nothing
+    left to do. GitHub keeps pending review requests on closed PRs. PRs where the user is only assignee, or which they
+    added to the board themselves, aren't affected. Team review requests aren't counted since the user's team
+    memberships are unknown.
+
+    >>> github_pr = {
+    ...     'state': 'CLOSED', 'closed': True, 'author': {'login': 'alice'}, 'reviewRequests': [{'login': 'me'}],
+    ...     'reviews': [],
+    ... }
+    >>> closed_without_own_review(github_pr, 'me')
+    True
+    >>> closed_without_own_review(dict(github_pr, reviewRequests=[], assignees=[{'login': 'me'}]), 'me')  # assignee
+    False
+    >>> closed_without_own_review(dict(github_pr, author={'login': 'me'}), 'me')  # own PRs stay visible
+    False
+    >>> closed_without_own_review(dict(github_pr, reviews=[
+    ...     {'author': {'login': 'me'}, 'state': 'COMMENTED', 'submittedAt': '2024-01-01T00:00:00Z'}]), 'me')
+    False
+    >>> closed_without_own_review(dict(github_pr, state='MERGED'), 'me')
+    False
+    """
+
+    return (
+        github_pr['state'].lower() == 'closed'
+        and github_pr['closed']
+        and not is_same_login(github_pr['author']['login'], github_user)
+        and any(is_same_login(request.get('login'), github_user) for request in github_pr.get('reviewRequests', []))
+        and latest_own_review(github_pr, github_user) is None)
+
+
+def re_requested_review_at(github_pr, github_user):
+    """
+    If the user's review was requested again after they reviewed, returns the time of their latest review.
+
+    GitHub removes a review request once the reviewer submits a review, so being requested while already having
+    reviewed means the author re-requested the review.
+
+    >>> github_pr = {
+    ...     'reviewRequests': [{'__typename': 'User', 'login': 'me'}],
+    ...     'reviews': [
+    ...         {'author': {'login': 'me'}, 'state': 'CHANGES_REQUESTED', 'submittedAt': '2024-01-01T00:00:00Z'},
+    ...         {'author': {'login': 'me'}, 'state': 'COMMENTED', 'submittedAt': '2024-01-02T00:00:00Z'},
+    ...     ],
+    ... }
+    >>> re_requested_review_at(github_pr, 'me')
+    '2024-01-02T00:00:00Z'
+    >>> re_requested_review_at(dict(github_pr, reviewRequests=[]), 'me') is None  # not requested anymore
+    True
+    >>> re_requested_review_at(dict(github_pr, reviews=[]), 'me') is None  # first request
+    True
+    """
+
+    if not any(is_same_login(request.get('login'), github_user) for request in github_pr.get('reviewRequests', [])):
+        return None
+    review = latest_own_review(github_pr, github_user)
+    return review['submittedAt'] if review is not None else None
+
+
+def title_matches_any(title, compiled_patterns):
+    r"""
+    >>> patterns = [re.compile(p, re.IGNORECASE) for p in (r'^chore(\(deps\))?: bump ', 'release v[0-9]')]
+    >>> title_matches_any('chore(deps): bump pyyaml from 6.0.0 to 6.0.1', patterns)
+    True
+    >>> title_matches_any('Release v1.2.3', patterns)
+    True
+    >>> title_matches_any('Fix chore: bump script', patterns)
+    False
+    >>> title_matches_any('anything', [])
+    False
+    """
+
+    return any(pattern.search(title) for pattern in compiled_patterns)
+
+
+def commented_without_review(github_pr, github_user):
+    """
+    Whether the user participated in the discussion of a PR they're requested to review, without reviewing it.
+
+    >>> github_pr = {
+    ...     'reviewRequests': [{'__typename': 'User', 'login': 'me'}],
+    ...     'comments': [{'author': {'login': 'me'}, 'createdAt': '2024-01-01T00:00:00Z'}],
+    ...     'reviews': [],
+    ... }
+    >>> commented_without_review(github_pr, 'me')
+    True
+    >>> commented_without_review(github_pr, 'Me')  # logins are case-insensitive
+    True
+    >>> commented_without_review(dict(github_pr, comments=[]), 'me')  # haven't looked yet
+    False
+    >>> commented_without_review(dict(github_pr, reviewRequests=[]), 'me')  # not requested
+    False
+    >>> commented_without_review(dict(github_pr, reviews=[
+    ...     {'author': {'login': 'me'}, 'state': 'COMMENTED', 'submittedAt': '2024-01-02T00:00:00Z'}]), 'me')
+    False
+    """
+
+    return (
+        any(is_same_login(request.get('login'), github_user) for request in github_pr.get('reviewRequests', []))
+        and any(is_same_login(comment['author']['login'], github_user) for comment in github_pr.get('comments', []))
+        and not any(is_same_login(review['author']['login'], github_user) for review in github_pr.get('reviews', [])))
+
+
+def repo_matches_any(repo_name_with_owner, patterns):
+    """
+    >>> repo_matches_any('MyOrg/Noisy-Repo', ['myorg/*'])
+    True
+    >>> repo_matches_any('myorg/noisy-repo', ['other/*', '*/noisy-*'])
+    True
+    >>> repo_matches_any('myorg-fork/repo', ['myorg/*'])
+    False
+    >>> repo_matches_any('myorg/repo', [])
+    False
+    """
+
+    return any(fnmatch.fnmatchcase(repo_name_with_owner.lower(), pattern.lower()) for pattern in patterns)
+
+
+def parse_ignored_repo_patterns(text):
+    """
+    One `owner/repo` glob per line, as entered in the board's form. Blank lines are skipped.
+
+    >>> parse_ignored_repo_patterns('myorg/*\\n\\n  other/noisy-repo  \\n')
+    ['myorg/*', 'other/noisy-repo']
+    >>> parse_ignored_repo_patterns('noisy-repo')
+    Traceback (most recent call last):
+    ...
+    ValueError: Invalid repo pattern 'noisy-repo' (expected `owner/repo`, wildcards allowed)
+    """
+
+    patterns = [line.strip() for line in text.splitlines() if line.strip()]
+    if len(patterns) > MAX_IGNORED_REPO_PATTERNS:
+        raise ValueError(f'At most {MAX_IGNORED_REPO_PATTERNS} repo patterns are supported')
+    for pattern in patterns:
+        if not re.fullmatch(r'[\w.*?\[\]-]{1,100}/[\w.*?\[\]-]{1,100}', pattern):
+            raise ValueError(f'Invalid repo pattern {pattern!r} (expected `owner/repo`, wildcards allowed)')
+    return patterns
+
+
+def normalize_label(label):
+    """
+    User-defined PR labels are lowercase, short and URL-friendly so they work as board filter.
+
+    >>> normalize_label('  Blocked-On-Design ')
+    'blocked-on-design'
+    >>> normalize_label('needs design')
+    Traceback (most recent call last):
+    ...
+    ValueError: Invalid label 'needs design' (letters, digits, `.`, `_` and `-` only, at most 50 characters)
+    """
+
+    label = label.strip().lower()
+    if not re.fullmatch(r'[\w.-]{1,50}', label):
+        raise ValueError(f'Invalid label {label!r} (letters, digits, `.`, `_` and `-` only, at most 50 characters)')
+    return label
+
+
+def with_label(labels, label):
+    """
+    >>> with_label(['b'], 'a')
+    ['a', 'b']
+    >>> with_label(['a', 'b'], 'a')  # already there
+    ['a', 'b']
+    """
+
+    if label in labels:
+        return list(labels)
+    if len(labels) >= MAX_LABELS_PER_PR:
+        raise ValueError(f'At most {MAX_LABELS_PER_PR} labels per PR are supported')
+    return sorted([*labels, label])
+
+
+def without_label(labels, label):
+    """
+    >>> without_label(['a', 'b'], 'a')
+    ['b']
+    >>> without_label(['b'], 'a')  # already removed
+    ['b']
+    """
+
+    return [existing_label for existing_label in labels if existing_label != label]
+
+
+def pull_request_matches_filters(pr, filters, ignored_repo_patterns=()):
+    """
+    Board filters from the URL query string, e.g. `/?author=someone&status=must-review,snoozed&repo=org/repo`. All
+    given filters must match. Deleted PRs are hidden unless explicitly requested with the status filter. Same for PRs
+    of ignored repos and the repo filter.
+
+    >>> pr = {
+    ...     'github_fields': {'author': {'login': 'SomeOne'}, 'repository': {'nameWithOwner': 'Org/Repo'}},
+    ...     'workboard_fields': {'status': 'must-review'},
+    ... }
+    >>> pull_request_matches_filters(pr, {})
+    True
+    >>> pull_request_matches_filters(pr, {'author': 'someone'})
+    True
+    >>> pull_request_matches_filters(pr, {'author': 'someone-else'})
+    False
+    >>> pull_request_matches_filters(pr, {'status': 'snoozed,must-review', 'repo': 'org/repo'})
+    True
+    >>> pull_request_matches_filters(pr, {'status': 'must-review', 'repo': 'org/other-repo'})
+    False
+    >>> pull_request_matches_filters(pr, {'status': 'snoozed', 'repo': 'org/repo'})
+    False
+    >>> deleted_pr = {**pr, 'workboard_fields': {'status': 'deleted'}}
+    >>> pull_request_matches_filters(deleted_pr, {})
+    False
+    >>> pull_request_matches_filters(deleted_pr, {'status': 'deleted'})
+    True
+    >>> pull_request_matches_filters(pr, {}, ignored_repo_patterns=['org/*'])
+    False
+    >>> pull_request_matches_filters(pr, {'repo': 'org/repo'}, ignored_repo_patterns=['org/*'])
+    True
+    >>> pull_request_matches_filters(pr, {'label': 'blocked'})
+    False
+    >>> labeled_pr = {**pr, 'workboard_fields': {'status': 'must-review', 'labels': ['blocked', 'team-x']}}
+    >>> pull_request_matches_filters(labeled_pr, {'label': 'blocked'})
+    True
+    """
+
+    if 'author' in filters and pr['github_fields']['author']['login'].lower() != filters['author'].lower():
+        return False
+    if 'status' in filters:
+        if pr['workboard_fields']['status'] not in filters['status'].split(','):
+            return False
+    elif pr['workboard_fields']['status'] == PullRequestStatus.DELETED:
+        return False
+    if 'repo' in filters:
+        if pr['github_fields']['repository']['nameWithOwner'].lower() != filters['repo'].lower():
+            return False
+    elif repo_matches_any(pr['github_fields']['repository']['nameWithOwner'], ignored_repo_patterns):
+        return False
+    if 'label' in filters and filters['label'] not in pr['workboard_fields'].get('labels', []):
+        return False
+    return True
+
+
+def pull_request_urls_in_repo(pull_requests, repo_name_with_owner):
+    """
+    >>> pull_requests = {
+    ...     'https://github.com/a/b/pull/1': {'github_fields': {'repository': {'nameWithOwner': 'a/b'}}},
+    ...     'https://github.com/a/b/pull/2': {'github_fields': {'repository': {'nameWithOwner': 'a/b'}}},
+    ...     'https://github.com/a/bc/pull/3': {'github_fields': {'repository': {'nameWithOwner': 'a/bc'}}},
+    ... }
+    >>> pull_request_urls_in_repo(pull_requests, 'a/b')
+    ['https://github.com/a/b/pull/1', 'https://github.com/a/b/pull/2']
+    >>> pull_request_urls_in_repo(pull_requests, 'x/y')
+    []
+    """
+
+    return sorted(
+        pr_url
+        for pr_url, pr in pull_requests.items()
+        if pr['github_fields']['repository']['nameWithOwner'] == repo_name_with_owner)
+
+
+def is_overdue(workboard_fields, now):
+    """
+    >>> is_overdue({'status': 'must-review', 'due': 1000}, now=1001)
+    True
+    >>> is_overdue({'status': 'must-review', 'due': 1000}, now=999)
+    False
+    >>> is_overdue({'status': 'merged', 'due': 1000}, now=1001)  # nothing to do anymore
+    False
+    >>> is_overdue({'status': 'must-review'}, now=1001)
+    False
+    """
+
+    return (workboard_fields.get('due', now) < now
+            and workboard_fields['status'] not in (
+                PullRequestStatus.CLOSED, PullRequestStatus.DELETED, PullRequestStatus.MERGED))
+
+
+def pull_request_sort_key(pr):
+    # Focused PRs are displayed on top, then overdue ones. Otherwise, PRs with latest changes are displayed on top,
+    # ordered by status.
+    return (
+        0 if pr['workboard_fields'].get('focus_until', 0) > time.time() else 1,
+        0 if is_overdue(pr['workboard_fields'], now=time.time()) else 1,
+        PR_STATUS_SORT_ORDER[pr['workboard_fields']['status']],
+        -github_datetime_to_timestamp(pr['github_fields']['updatedAt']),
+        -pr['workboard_fields'].get('last_change', 2**63),
+    )
+
+
+def next_pull_request_url(pull_requests, excluded_pr_urls):
+    """
+    Highest-priority actionable PR for the review queue, or `None` if there's nothing left to do.
+
+    >>> def pr(status, updated_at):
+    ...     return {'github_fields': {'updatedAt': updated_at}, 'workboard_fields': {'status': status}}
+    >>> pull_requests = {
+    ...     'https://github.com/a/b/pull/1': pr('must-review', '2024-01-01T00:00:00Z'),
+    ...     'https://github.com/a/b/pull/2': pr('must-review', '2024-02-01T00:00:00Z'),
+    ...     'https://github.com/a/b/pull/3': pr('updated-after-snooze', '2023-01-01T00:00:00Z'),
+    ...     'https://github.com/a/b/pull/4': pr('snoozed-until-update', '2024-03-01T00:00:00Z'),
+    ... }
+    >>> next_pull_request_url(pull_requests, excluded_pr_urls=set())
+    'https://github.com/a/b/pull/3'
+    >>> next_pull_request_url(pull_requests, excluded_pr_urls={'https://github.com/a/b/pull/3'})
+    'https://github.com/a/b/pull/2'
+    >>> next_pull_request_url(pull_requests, excluded_pr_urls=set(pull_requests)) is None
+    True
+    """
+
+    candidates = sorted(
+        (
+            (pull_request_sort_key(pr), pr_url)
+            for pr_url, pr in pull_requests.items()
+            if pr['workboard_fields']['status'] in ACTIONABLE_PR_STATUSES and pr_url not in excluded_pr_urls
+        ),
+    )
+    return candidates[0][1] if candidates else None
+
+
+def activity_based_snooze_until(updated_at, duration_seconds, snooze_until_max):
+    """
+    Snooze end for "snooze for some time after the last activity", pushed out by new activity but bounded.
+
+    >>> activity_based_snooze_until('2024-01-01T00:00:00Z', 86400, snooze_until_max=1704326400)
+    1704153600
+    >>> # New activity a day later moves the snooze end accordingly...
+    >>> activity_based_snooze_until('2024-01-02T00:00:00Z', 86400, snooze_until_max=1704326400)
+    1704240000
+    >>> # ...but never beyond the maximum
+    >>> activity_based_snooze_until('2024-01-05T00:00:00Z', 86400, snooze_until_max=1704326400)
+    1704326400
+    """
+
+    return min(github_datetime_to_timestamp(updated_at) + duration_seconds, snooze_until_max)
+
+
+def restored_status(workboard_fields, github_state):
+    """
+    Status for undoing a deletion. Merged/closed PRs can't be worked on anymore, so we show them as such.
+
+    >>> print(restored_status({'status': 'deleted', 'status_before_delete': 'snoozed-until-update'}, 'OPEN'))
+    snoozed-until-update
+    >>> print(restored_status({'status': 'deleted', 'status_before_delete': 'reviewed-delete-on-merge'}, 'MERGED'))
+    merged
+    >>> print(restored_status({'status': 'deleted', 'status_before_delete': 'must-review'}, 'CLOSED'))
+    closed
+    >>> print(restored_status({'status': 'deleted'}, 'OPEN'))  # deleted before we stored the previous status
+    must-review
+    >>> print(restored_status({'status': 'deleted', 'status_before_delete': 'deleted'}, 'OPEN'))  # deleted twice
+    must-review
+    """
+
+    if github_state.lower() == 'merged':
+        return PullRequestStatus.MERGED
+    if github_state.lower() == 'closed':
+        return PullRequestStatus.CLOSED
+    status_before_delete = workboard_fields.get('status_before_delete', PullRequestStatus.MUST_REVIEW)
+    # Older versions stored this when an already deleted PR was deleted again
+    if status_before_delete == PullRequestStatus.DELETED:
+        return PullRequestStatus.MUST_REVIEW
+    return status_before_delete
+
+
+def next_workday_morning(now, next_week, tz=None, hour=9):
+    """
+    Snooze end for "tomorrow morning" (skipping weekends) or "next week" (Monday morning). Computed in local time
+    (`tz=None`), so the hour stays the same across daylight saving time changes.
+
+    >>> import zoneinfo
+    >>> berlin = zoneinfo.ZoneInfo('Europe/Berlin')
+    >>> def fmt(timestamp):
+    ...     return datetime.datetime.fromtimestamp(timestamp, berlin).strftime('%a %Y-%m-%d %H:%M %Z')
+    >>> wednesday = datetime.datetime(2024, 3, 27, 15, 0, tzinfo=berlin).timestamp()
+    >>> fmt(next_workday_morning(wednesday, next_week=False, tz=berlin))
+    'Thu 2024-03-28 09:00 CET'
+    >>> # Across a weekend with the switch to daylight saving time
+    >>> friday = datetime.datetime(2024, 3, 29, 15, 0, tzinfo=berlin).timestamp()
+    >>> fmt(next_workday_morning(friday, next_week=False, tz=berlin))
+    'Mon 2024-04-01 09:00 CEST'
+    >>> fmt(next_workday_morning(wednesday, next_week=True, tz=berlin))
+    'Mon 2024-04-01 09:00 CEST'
+    >>> # On Monday, "next week" means the Monday after
+    >>> monday_morning = datetime.datetime(2024, 4, 1, 8, 0, tzinfo=berlin).timestamp()
+    >>> fmt(next_workday_morning(monday_morning, next_week=True, tz=berlin))
+    'Mon 2024-04-08 09:00 CEST'
+    """
+
+    day = datetime.datetime.fromtimestamp(now, tz).date()
+    if next_week:
+        day += datetime.timedelta(days=7 - day.weekday())
+    else:
+        day += datetime.timedelta(days=1)
+        while day.weekday() >= 5:
+            day += datetime.timedelta(days=1)
+    return datetime.datetime.combine(day, datetime.time(hour), tzinfo=tz).timestamp()
+
+
+def load_timezone(name):
+    """
+    Time zone from the `timezone` config, or `None` (server's local time) if not configured.
+
+    >>> load_timezone('Europe/Berlin')
+    zoneinfo.ZoneInfo(key='Europe/Berlin')
+    >>> print(load_timezone(None))
+    None
+    >>> load_timezone('Mars/Olympus_Mons')
+    Traceback (most recent call last):
+    ...
+    ValueError: Unknown time zone 'Mars/Olympus_Mons' (expected e.g. `Europe/Berlin`)
+    >>> load_timezone('../etc/passwd')
+    Traceback (most recent call last):
+    ...
+    ValueError: Unknown time zone '../etc/passwd' (expected e.g. `Europe/Berlin`)
+    """
+
+    if name is None:
+        return None
+    try:
+        return zoneinfo.ZoneInfo(name)
+    except (zoneinfo.ZoneInfoNotFoundError, TypeError, ValueError) as e:
+        raise ValueError(f'Unknown time zone {name!r} (expected e.g. `Europe/Berlin`)') from e
+
+
+WEEKDAY_NAMES = ('monday', 'tuesday', 'wednesday', 'thursday', 'friday', 'saturday', 'sunday')
+
+
+def snooze_preset_until(until, now, tz=None, hour=9):
+    """
+    Snooze end for a preset of the board's snooze buttons: `1d`, `tomorrow-morning`, `next-week`, a weekday name (that
+    day's morning, at least one day ahead), or a duration like `3h` or `2d`. Mornings are computed in local time
+    (`tz=None`) like in `next_workday_morning`, durations are exact.
+
+    >>> import zoneinfo
+    >>> berlin = zoneinfo.ZoneInfo('Europe/Berlin')
+    >>> def fmt(timestamp):
+    ...     return datetime.datetime.fromtimestamp(timestamp, berlin).strftime('%a %Y-%m-%d %H:%M %Z')
+    >>> friday = datetime.datetime(2024, 3, 29, 15, 0, tzinfo=berlin).timestamp()
+    >>> fmt(snooze_preset_until('tuesday', friday, tz=berlin))  # across the switch to daylight saving time
+    'Tue 2024-04-02 09:00 CEST'
+    >>> fmt(snooze_preset_until('friday', friday, tz=berlin))
+    'Fri 2024-04-05 09:00 CEST'
+    >>> fmt(snooze_preset_until('2d', friday, tz=berlin))  # 48 hours, so one hour later on the clock
+    'Sun 2024-03-31 16:00 CEST'
+    >>> fmt(snooze_preset_until('3h', friday, tz=berlin))
+    'Fri 2024-03-29 18:00 CET'
+    >>> fmt(snooze_preset_until('tomorrow-morning', friday, tz=berlin))
+    'Mon 2024-04-01 09:00 CEST'
+    >>> snooze_preset_until('0h', friday)
+    Traceback (most recent call last):
+    ...
+    ValueError: Invalid until
+    """
+
+    if until == '1d':
+        return now + 86400
+    if until in ('tomorrow-morning', 'next-week'):
+        return next_workday_morning(now, next_week=until == 'next-week', tz=tz, hour=hour)
+    if until in WEEKDAY_NAMES:
+        day = datetime.datetime.fromtimestamp(now, tz).date()
+        day += datetime.timedelta(days=(WEEKDAY_NAMES.index(until) - day.weekday() - 1) % 7 + 1)
+        return datetime.datetime.combine(day, datetime.time(hour), tzinfo=tz).timestamp()
+    m = re.fullmatch(r'([1-9][0-9]{0,2})([hd])', until)
+    if m is not None:
+        return now + int(m.group(1)) * (3600 if m.group(2) == 'h' else 86400)
+    raise ValueError('Invalid until')
+
+
+# Sort options of the board (URL query `?sort=...`). Each has a natural direction, reversed by `&reverse=1`.
+BOARD_SORT_OPTIONS = {
+    'priority': ('priority', pull_request_sort_key),
+    'updated': (
+        'last GitHub update',
+        lambda pr: -github_datetime_to_timestamp(pr['github_fields']['updatedAt'])),
+    'last_change': (
+        'last status change',
+        lambda pr: -pr['workboard_fields'].get('last_change', 0)),
+    'status': ('status', lambda pr: pr['workboard_fields']['status']),
+}
+
+
+def sort_pull_requests(pull_requests, sort_by, reverse):
+    """
+    Ties are ordered by URL so that the output is deterministic.
+
+    >>> def pr(url, status, updated_at, last_change=None):
+    ...     workboard_fields = {'status': status}
+    ...     if last_change is not None:
+    ...         workboard_fields['last_change'] = last_change
+    ...     return {'github_fields': {'url': url, 'updatedAt': updated_at}, 'workboard_fields': workboard_fields}
+    >>> pull_requests = [
+    ...     pr('https://github.com/a/b/pull/2', 'must-review', '2024-01-01T00:00:00Z', last_change=100),
+    ...     pr('https://github.com/a/b/pull/1', 'closed', '2024-01-01T00:00:00Z'),
+    ...     pr('https://github.com/a/b/pull/3', 'unknown', '2024-02-01T00:00:00Z', last_change=50),
+    ... ]
+    >>> urls = lambda prs: [pr['github_fields']['url'][-1] for pr in prs]
+    >>> urls(sort_pull_requests(pull_requests, 'updated', reverse=False))
+    ['3', '1', '2']
+    >>> urls(sort_pull_requests(pull_requests, 'updated', reverse=True))
+    ['1', '2', '3']
+    >>> urls(sort_pull_requests(pull_requests, 'last_change', reverse=False))  # missing timestamp sorts last
+    ['2', '3', '1']
+    >>> urls(sort_pull_requests(pull_requests, 'status', reverse=False))
+    ['1', '2', '3']
+    """
+
+    by_url = sorted(pull_requests, key=lambda pr: pr['github_fields']['url'])
+    # Python's sort is stable (also with `reverse=True`), keeping ties ordered by URL
+    return sorted(by_url, key=BOARD_SORT_OPTIONS[sort_by][1], reverse=reverse)
+
+
+def normalize_default_view_query(query_string):
+    """
+    Validates a board query string which the user wants as default view (see `ui-preferences` database key). The
+    pagination cursor is dropped since it only makes sense for one listing.
+
+    >>> normalize_default_view_query('status=must-review,unknown&sort=updated&reverse=1')
+    'status=must-review%2Cunknown&sort=updated&reverse=1'
+    >>> normalize_default_view_query('page_size=50&after=https://github.com/a/b/pull/1')
+    'sort=priority&page_size=50'
+    >>> normalize_default_view_query('sort=bogus')
+    Traceback (most recent call last):
+    ...
+    ValueError: Invalid sort option 'bogus'
+    >>> normalize_default_view_query('author=' + 'x' * 1000)
+    Traceback (most recent call last):
+    ...
+    ValueError: Default view query is too long
+    """
+
+    if len(query_string) > MAX_DEFAULT_VIEW_QUERY_LENGTH:
+        raise ValueError('Default view query is too long')
+    board_query = ServerHandler._parse_board_query(query_string)
+    return urlencode(ServerHandler._get_board_query_params(board_query))
+
+
+def paginate(pull_requests, page_size, after_pr_url):
+    """
+    Cursor-based pagination of a sorted list. Returns the page and the cursor for the next page (`None` if last page).
+
+    The cursor is the URL of the last PR on the previous page, so it stays valid if other PRs are added meanwhile.
+
+    >>> pull_requests = [{'github_fields': {'url': f'https://github.com/a/b/pull/{n}'}} for n in range(1, 6)]
+    >>> urls = lambda prs: [pr['github_fields']['url'][-1] for pr in prs]
+    >>> page, next_after = paginate(pull_requests, 2, None)
+    >>> urls(page), next_after
+    (['1', '2'], 'https://github.com/a/b/pull/2')
+    >>> page, next_after = paginate(pull_requests, 2, 'https://github.com/a/b/pull/4')  # partial final page
+    >>> urls(page), next_after
+    (['5'], None)
+    >>> paginate(pull_requests, 2, 'https://github.com/a/b/pull/5')
+    ([], None)
+    >>> paginate([], 2, None)
+    ([], None)
+    >>> paginate(pull_requests, 2, 'https://github.com/a/b/pull/999')
+    Traceback (most recent call last):
+    ...
+    ValueError: Page cursor is invalid or expired (PR is no longer listed), please start from the first page
+    """
+
+    start = 0
+    if after_pr_url is not None:
+        urls = [pr['github_fields']['url'] for pr in pull_requests]
+        if after_pr_url not in urls:
+            raise ValueError(
+                'Page cursor is invalid or expired (PR is no longer listed), please start from the first page')
+        start = urls.index(after_pr_url) + 1
+
+    page = pull_requests[start:start + page_size]
+    next_after_pr_url = page[-1]['github_fields']['url'] if page and start + page_size < len(pull_requests) else None
+    return page, next_after_pr_url
+
+
+class Metrics:
+    """
+    Minimal in-process metrics, rendered in Prometheus text format. Avoids a dependency for a handful of values.
+
+    >>> metrics = Metrics()
+    >>> metrics.inc('workboard_github_commands_total', {'command': 'pr view', 'result': 'success'})
+    >>> metrics.inc('workboard_github_commands_total', {'command': 'pr view', 'result': 'success'})
+    >>> metrics.observe('workboard_github_command_duration_seconds', {'command': 'pr view'}, 0.5)
+    >>> print(metrics.render(gauges=[('workboard_pull_requests', {'status': 'must-review'}, 3)]), end='')
+    # TYPE workboard_github_command_duration_seconds summary
+    workboard_github_command_duration_seconds_count{command="pr view"} 1
+    workboard_github_command_duration_seconds_sum{command="pr view"} 0.5
+    # TYPE workboard_github_commands_total counter
+    workboard_github_commands_total{command="pr view",result="success"} 2
+    # TYPE workboard_pull_requests gauge
+    workboard_pull_requests{status="must-review"} 3
+    """
+
+    def __init__(self):
+        self._lock = threading.Lock()
+        # Metric name => (type, {(name suffix, labels): value})
+        self._families = {}
+
+    def inc(self, name, labels, value=1):
+        self._add(name, 'counter', '', labels, value)
+
+    def observe(self, name, labels, value):
+        self._add(name, 'summary', '_sum', labels, value)
+        self._add(name, 'summary', '_count', labels, 1)
+
+    def _add(self, name, metric_type, suffix, labels, value):
+        with self._lock:
+            _, samples = self._families.setdefault(name, (metric_type, {}))
+            key = (suffix, tuple(sorted(labels.items())))
+            samples[key] = samples.get(key, 0) + value
+
+    def render(self, gauges=()):
+        """
+        `gauges` are computed by the caller at scrape time, as (name, labels, value) tuples.
+        """
+
+        with self._lock:
+            families = {
+                name: (metric_type, sorted(samples.items()))
+                for name, (metric_type, samples) in self._families.items()
+            }
+        for name, labels, value in gauges:
+            families.setdefault(name, ('gauge', []))[1].append((('', tuple(sorted(labels.items()))), value))
+
+        def format_labels(labels):
+            if not labels:
+                return ''
+            escaped = (
+                (k, str(v).replace('\\', '\\\\').replace('"', '\\"').replace('\n', '\\n'))
+                for k, v in labels)
+            return '{' + ','.join(f'{k}="{v}"' for k, v in escaped) + '}'
+
+        lines = []
+        for name, (metric_type, samples) in sorted(families.items()):
+            lines.append(f'# TYPE {name} {metric_type}')
+            for (suffix, labels), value in samples:
+                lines.append(f'{name}{suffix}{format_labels(labels)} {value:g}')
+        return ''.join(f'{line}\n' for line in lines)
+
+
+METRICS = Metrics()
+
+
+def per_repo_status_counts(pull_requests, max_repos):
+    """
+    Counts PRs by `(repo, status)`. Only the `max_repos` repos with most PRs get their own label value, all others are
+    bucketed into `other` since Prometheus doesn't cope well with unbounded label values.
+
+    >>> pr = lambda repo, status: {
+    ...     'github_fields': {'repository': {'nameWithOwner': repo}}, 'workboard_fields': {'status': status}}
+    >>> pull_requests = [
+    ...     pr('org/big', 'must-review'), pr('org/big', 'must-review'), pr('org/big', 'snoozed-until-update'),
+    ...     pr('org/medium', 'must-review'), pr('org/medium', 'merged'),
+    ...     pr('org/small', 'must-review'), pr('org/tiny', 'must-review'),
+    ... ]
+    >>> for key, count in sorted(per_repo_status_counts(pull_requests, 2).items()):
+    ...     print(key, count)
+    ('org/big', 'must-review') 2
+    ('org/big', 'snoozed-until-update') 1
+    ('org/medium', 'merged') 1
+    ('org/medium', 'must-review') 1
+    ('other', 'must-review') 2
+    >>> per_repo_status_counts(pull_requests[:1], 0)
+    {('other', 'must-review'): 1}
+    >>> per_repo_status_counts([], 2)
+    {}
+    """
+
+    repo_totals = {}
+    for pr in pull_requests:
+        repo = pr['github_fields']['repository']['nameWithOwner']
+        repo_totals[repo] = repo_totals.get(repo, 0) + 1
+    # Ties are broken by name so that the selection doesn't flap between scrapes
+    top_repos = set(sorted(repo_totals, key=lambda repo: (-repo_totals[repo], repo))[:max_repos])
+
+    counts = {}
+    for pr in pull_requests:
+        repo = pr['github_fields']['repository']['nameWithOwner']
+        key = (repo if repo in top_repos else 'other', str(pr['workboard_fields']['status']))
+        counts[key] = counts.get(key, 0) + 1
+    return counts
+
+
+def pull_request_statistics(pull_requests, now, wake_within_seconds=86400):
+    """
+    Summary of the board in one pass: counts by status and by repo (deleted PRs only count by status), how many
+    PRs snoozed until a time wake up soon, and when the least recently refreshed PR was last updated from GitHub.
+
+    >>> pr = lambda repo, status, **workboard_fields: {
+    ...     'github_fields': {'repository': {'nameWithOwner': repo}},
+    ...     'workboard_fields': dict(workboard_fields, status=status)}
+    >>> statistics = pull_request_statistics([
+    ...     pr('org/a', 'must-review', last_refreshed_at=900),
+    ...     pr('org/a', 'snoozed-until-time', snooze_until=1000 + 3600, last_refreshed_at=950),
+    ...     pr('org/b', 'snoozed-until-time', snooze_until=1000 + 86400 * 3),
+    ...     pr('org/b', 'deleted', last_refreshed_at=10),
+    ... ], now=1000)
+    >>> statistics['by_status']
+    {'deleted': 1, 'must-review': 1, 'snoozed-until-time': 2}
+    >>> statistics['by_repo']
+    {'org/a': 2, 'org/b': 1}
+    >>> statistics['waking_up_soon'], statistics['oldest_refresh_at']
+    (1, 900)
+    >>> pull_request_statistics([], now=1000)['oldest_refresh_at'] is None
+    True
+    """
+
+    by_status = {}
+    by_repo = {}
+    waking_up_soon = 0
+    oldest_refresh_at = None
+    for pr in pull_requests:
+        status = pr['workboard_fields']['status']
+        by_status[str(status)] = by_status.get(str(status), 0) + 1
+        if status == PullRequestStatus.DELETED:
+            continue
+        repo = pr['github_fields']['repository']['nameWithOwner']
+        by_repo[repo] = by_repo.get(repo, 0) + 1
+        if (status == PullRequestStatus.SNOOZED_UNTIL_TIME
+                and pr['workboard_fields']['snooze_until'] <= now + wake_within_seconds):
+            waking_up_soon += 1
+        # Not set for PRs from before this field existed
+        refreshed_at = pr['workboard_fields'].get('last_refreshed_at')
+        if refreshed_at is not None and (oldest_refresh_at is None or refreshed_at < oldest_refresh_at):
+            oldest_refresh_at = refreshed_at
+    return {
+        'by_status': dict(sorted(by_status.items())),
+        'by_repo': dict(sorted(by_repo.items())),
+        'waking_up_soon': waking_up_soon,
+        'oldest_refresh_at': oldest_refresh_at,
+    }
+
+
+def is_repo_not_found_error(stderr):
+    """
+    >>> is_repo_not_found_error("GraphQL: Could not resolve to a Repository with the name 'org/repo'. (repository)")
+    True
+    >>> is_repo_not_found_error('GraphQL: Could not resolve to a PullRequest with the number of 5. (repository)')
+    False
+    >>> is_repo_not_found_error('HTTP 502: Bad Gateway')
+    False
+    """
+
+    return 'Could not resolve to a Repository' in stderr
+
+
+def gone_status_for_error(error):
+    """
+    Status for a PR which can't be fetched anymore because GitHub doesn't know the repo or the PR, or `None` for other
+    errors.
+
+    >>> print(gone_status_for_error(GitHubCommandError('x', "Could not resolve to a Repository with the name 'o/r'.")))
+    repo-gone
+    >>> print(gone_status_for_error(GitHubCommandError('x', 'Could not resolve to a PullRequest with the number of 5')))
+    gone
+    >>> print(gone_status_for_error(GitHubCommandError('x', 'HTTP 502: Bad Gateway')))
+    None
+    >>> print(gone_status_for_error(FileNotFoundError('gh')))
+    None
+    """
+
+    if not isinstance(error, GitHubCommandError):
+        return None
+    if is_repo_not_found_error(error.stderr):
+        return PullRequestStatus.REPO_GONE
+    if 'Could not resolve to a PullRequest' in error.stderr:
+        return PullRequestStatus.GONE
+    return None
+
+
+def is_transient_github_error(stderr):
+    """
+    Server errors and network problems which may well succeed on the next try. Client errors (4xx) never do.
+
+    >>> is_transient_github_error('HTTP 502: Bad Gateway (https://api.github.com/graphql)')
+    True
+    >>> is_transient_github_error('Post "https://api.github.com/graphql": read tcp 10.0.0.2:54321->1.2.3.4:443: '
+    ...                           'read: connection reset by peer')
+    True
+    >>> is_transient_github_error('error connecting to api.github.com')
+    True
+    >>> is_transient_github_error('HTTP 404: Not Found (https://api.github.com/repos/org/repo/releases)')
+    False
+    >>> is_transient_github_error('GraphQL: Could not resolve to a PullRequest with the number of 5.')
+    False
+    """
+
+    return bool(re.search(
+        r'HTTP 5\d\d|connection reset|connection refused|i/o timeout|TLS handshake timeout|unexpected EOF|'
+        r'error connecting to|no such host',
+        stderr))
+
+
+def run_with_retries(func, max_attempts, on_retry=None, sleep=time.sleep, random_fraction=random.random):
+    """
+    Calls `func` until it doesn't fail with a transient GitHub error anymore, waiting exponentially longer between
+    attempts (1s, 2s, 4s, ... with jitter so that concurrent fetches don't retry in lockstep). `on_retry` gets the
+    error and delay before each retry.
+
+    >>> failures = [GitHubCommandError('x', 'HTTP 502: Bad Gateway'), GitHubCommandError('x', 'HTTP 503')]
+    >>> def flaky():
+    ...     if failures:
+    ...         raise failures.pop(0)
+    ...     return 'result'
+    >>> delays = []
+    >>> run_with_retries(flaky, 3, sleep=delays.append, random_fraction=lambda: 1.0)
+    'result'
+    >>> delays
+    [1.0, 2.0]
+    >>> failures = [GitHubCommandError('x', 'HTTP 502: Bad Gateway')] * 3
+    >>> run_with_retries(flaky, 3, sleep=delays.append)  # doctest: +IGNORE_EXCEPTION_DETAIL
+    Traceback (most recent call last):
+    ...
+    GitHubCommandError: x
+    >>> failures = [GitHubCommandError('x', 'HTTP 404: Not Found'), GitHubCommandError('x', 'HTTP 502')]
+    >>> run_with_retries(flaky, 3, sleep=delays.append)  # doctest: +IGNORE_EXCEPTION_DETAIL
+    Traceback (most recent call last):
+    ...
+    GitHubCommandError: x
+    >>> failures  # not retried
+    [GitHubCommandError('x')]
+    """
+
+    for attempt in range(max_attempts):
+        try:
+            return func()
+        except GitHubCommandError as e:
+            if attempt + 1 >= max_attempts or not is_transient_github_error(e.stderr):
+                raise
+            delay_seconds = min(2 ** attempt, 30) * (0.5 + random_fraction() / 2)
+            if on_retry is not None:
+                on_retry(e, delay_seconds)
+            sleep(delay_seconds)
+
+
+def is_valid_webhook_signature(secret, body, signature_header):
+    """
+    Checks the `X-Hub-Signature-256` header of a GitHub webhook delivery.
+
+    >>> body = b'{"action": "opened"}'
+    >>> signature = 'sha256=' + hmac.new(b'secret', body, hashlib.sha256).hexdigest()
+    >>> is_valid_webhook_signature('secret', body, signature)
+    True
+    >>> is_valid_webhook_signature('other-secret', body, signature)
+    False
+    >>> is_valid_webhook_signature('secret', body + b' ', signature)
+    False
+    >>> is_valid_webhook_signature('secret', body, None)
+    False
+    """
+
+    if not signature_header or not signature_header.startswith('sha256='):
+        return False
+    expected = 'sha256=' + hmac.new(secret.encode('utf-8'), body, hashlib.sha256).hexdigest()
+    return hmac.compare_digest(expected, signature_header)
+
+
+def github_pr_from_webhook(event, payload):
+    """
+    Affected PR of a GitHub webhook event, in the format of `gh search prs` results, plus the logins involved with the
+    PR (author, assignees, requested reviewers). `None` for events which aren't about a PR.
+
+    >>> repository = {'full_name': 'org/repo', 'name': 'repo'}
+    >>> pull_request = {
+    ...     'html_url': 'https://github.com/org/repo/pull/1', 'title': 'Fix', 'state': 'open',
+    ...     'updated_at': '2024-01-01T00:00:00Z', 'user': {'login': 'alice'}, 'assignees': [{'login': 'bob'}],
+    ...     'requested_reviewers': [{'login': 'me'}],
+    ... }
+    >>> github_pr, involved_logins = github_pr_from_webhook(
+    ...     'pull_request_review', {'pull_request': pull_request, 'repository': repository})
+    >>> github_pr['url'], github_pr['author'], github_pr['repository']
+    ('https://github.com/org/repo/pull/1', {'login': 'alice'}, {'nameWithOwner': 'org/repo', 'name': 'repo'})
+    >>> sorted(involved_logins)
+    ['alice', 'bob', 'me']
+    >>> issue = dict(pull_request, pull_request={'html_url': 'https://github.com/org/repo/pull/1'})
+    >>> github_pr_from_webhook('issue_comment', {'issue': issue, 'repository': repository})[0]['url']
+    'https://github.com/org/repo/pull/1'
+    >>> print(github_pr_from_webhook('issue_comment', {'issue': pull_request, 'repository': repository}))  # no PR
+    None
+    >>> print(github_pr_from_webhook('push', {'repository': repository}))
+    None
+    """
+
+    if event in ('pull_request', 'pull_request_review'):
+        pull_request = payload['pull_request']
+    elif event == 'issue_comment' and 'pull_request' in payload['issue']:
+        # Comments on the conversation tab of a PR come as issue comments
+        pull_request = payload['issue']
+    else:
+        return None
+
+    github_pr = {
+        'url': pull_request['html_url'],
+        'title': pull_request['title'],
+        'state': pull_request['state'],
+        'updatedAt': pull_request['updated_at'],
+        'author': {'login': pull_request['user']['login']},
+        'repository': {'nameWithOwner': payload['repository']['full_name'], 'name': payload['repository']['name']},
+    }
+    involved_logins = {pull_request['user']['login']}
+    involved_logins.update(user['login'] for user in pull_request.get('assignees', []))
+    involved_logins.update(user['login'] for user in pull_request.get('requested_reviewers', []))
+    return github_pr, involved_logins
+
+
+def is_rate_limit_error(stderr):
+    """
+    >>> is_rate_limit_error('GraphQL: API rate limit exceeded for user ID 1234.')
+    True
+    >>> is_rate_limit_error('HTTP 403: You have exceeded a secondary rate limit. Please wait a few minutes before you '
+    ...                     'try again. (https://api.github.com/search/issues?q=...)')
+    True
+    >>> is_rate_limit_error('HTTP 403: Resource not accessible by integration')
+    False
+    """
+
+    return 'rate limit exceeded' in stderr or 'secondary rate limit' in stderr
+
+
+def is_systemic_github_error(stderr):
+    """
+    Errors which would make every `gh` call fail, so there's no point in showing the board with stale data.
+
+    >>> is_systemic_github_error('To get started with GitHub CLI, please run:  gh auth login')
+    True
+    >>> is_systemic_github_error('HTTP 401: Bad credentials (https://api.github.com/graphql)')
+    True
+    >>> is_systemic_github_error('GraphQL: API rate limit exceeded for user ID 1234.')
+    True
+    >>> is_systemic_github_error('GraphQL: Could not resolve to a PullRequest with the number of 5. (repository)')
+    False
+    """
+
+    return ('gh auth login' in stderr
+            or 'HTTP 401' in stderr
+            or 'Bad credentials' in stderr
+            or is_rate_limit_error(stderr))
+
+
+def rate_limit_quota(resources):
+    """
+    Remaining quota of the GitHub API resources which workboard uses, from the `resources` of the `GET /rate_limit`
+    response.
+
+    >>> resources = {
+    ...     'core': {'limit': 5000, 'used': 1, 'remaining': 4999, 'reset': 1700003600},
+    ...     'graphql': {'limit': 5000, 'used': 4950, 'remaining': 50, 'reset': 1700001800},
+    ...     'search': {'limit': 30, 'used': 0, 'remaining': 30, 'reset': 1700000060},
+    ... }
+    >>> for quota in rate_limit_quota(resources):
+    ...     print(quota)
+    {'name': 'graphql', 'remaining': 50, 'limit': 5000, 'reset': 1700001800, 'low': True}
+    {'name': 'search', 'remaining': 30, 'limit': 30, 'reset': 1700000060, 'low': False}
+    >>> rate_limit_quota({})
+    []
+    """
+
+    return [
+        {
+            'name': name,
+            'remaining': resources[name]['remaining'],
+            'limit': resources[name]['limit'],
+            'reset': resources[name]['reset'],
+            'low': resources[name]['remaining'] < min_remaining,
+        }
+        for name, min_remaining in sorted(RATE_LIMIT_MIN_REMAINING.items())
+        if name in resources
+    ]
+
+
+def split_fetch_results(results):
+    """
+    Splits `(github_pr, error)` pairs into the successfully fetched PRs and per-PR error descriptions, so that a single
+    broken PR doesn't fail the whole board. Raises for errors that aren't specific to one PR.
+
+    >>> fetched, fetch_errors = split_fetch_results([
+    ...     ({'url': 'https://github.com/org/repo/pull/1'}, None),
+    ...     ({'url': 'https://github.com/org/repo/pull/2'}, GitHubCommandError(
+    ...         'Command failed', 'HTTP 502: Bad Gateway\\nmore details')),
+    ...     ({'url': 'https://github.com/org/repo/pull/3'}, None),
+    ... ])
+    >>> [github_pr['url'] for github_pr in fetched]
+    ['https://github.com/org/repo/pull/1', 'https://github.com/org/repo/pull/3']
+    >>> fetch_errors
+    [{'pr_url': 'https://github.com/org/repo/pull/2', 'error': 'HTTP 502: Bad Gateway'}]
+    >>> split_fetch_results([  # doctest: +IGNORE_EXCEPTION_DETAIL
+    ...     ({'url': 'https://github.com/org/repo/pull/1'}, GitHubCommandError('Command failed', 'HTTP 401')),
+    ... ])
+    Traceback (most recent call last):
+    ...
+    GitHubCommandError: Command failed
+    >>> split_fetch_results([({'url': 'https://github.com/org/repo/pull/1'}, FileNotFoundError('gh'))])
+    Traceback (most recent call last):
+    ...
+    FileNotFoundError: gh
+    """
+
+    fetched = []
+    fetch_errors = []
+    for github_pr, error in results:
+        if error is None:
+            fetched.append(github_pr)
+        elif (isinstance(error, GitHubCommandError)
+                and not isinstance(error, GitHubRateLimitError)
+                and not is_systemic_github_error(error.stderr)):
+            fetch_errors.append({
+                'pr_url': github_pr['url'],
+                'error': error.stderr.strip().split('\n')[0] or str(error),
+            })
+        else:
+            raise error
+    return fetched, fetch_errors
+
+
+def run_hook(command, payload, timeout_seconds):
+    r"""
+    Runs a user-configured command with a JSON payload on stdin. Never raises, since hooks must not break workboard.
+
+    >>> run_hook([sys.executable, '-c', 'import sys; sys.exit(0 if sys.stdin.read() == "{}" else 1)'], {}, 10)
+    'success'
+    >>> run_hook([sys.executable, '-c', 'import sys; print("oops", file=sys.stderr); sys.exit(3)'], {}, 10)
+    "exit code 3 (error output: 'oops\\n')"
+    >>> run_hook([sys.executable, '-c', 'import time; time.sleep(10)'], {}, 0.5)
+    'timeout after 0.5 second(s)'
+    >>> run_hook(['/nonexistent/hook'], {}, 10).startswith('failed to run: ')
+    True
+    """
+
+    try:
+        proc = subprocess.run(
+            command,
+            input=json.dumps(payload),
+            encoding='utf-8',
+            capture_output=True,
+            timeout=timeout_seconds,
+            check=False)
+    except subprocess.TimeoutExpired:
+        return f'timeout after {timeout_seconds} second(s)'
+    except Exception as e:  # pylint: disable=broad-exception-caught
+        return f'failed to run: {e}'
+    if proc.returncode:
+        return f'exit code {proc.returncode} (error output: {proc.stderr!r})'
+    return 'success'
+
+
+def timed(desc, callback):
+    begin = time.perf_counter()
+    try:
+        ret = callback()
+    except:
+        end = time.perf_counter()
+        logging.error('%r raised an exception after %.2f second(s)', desc, end - begin)
+        raise
+    end = time.perf_counter()
+    logging.info('%r took %.2f second(s)', desc, end - begin)
+    return ret
+
+
+class ServerHandler(http.server.SimpleHTTPRequestHandler):
+    # Must be set class-wide from configuration files (read-only)
+    api_enabled = False
+    # Disabled if `None`
+    awaiting_response_hours = None
+    cache = None
+    delete_closed_unreviewed = False
+    exclude_title_patterns = []
+    github_fetch_concurrency = 4
+    github_host = None
+    github_max_attempts = 3
+    github_user = None
+    # Status => list of commands (each a list of arguments)
+    hooks_by_status = {}
+    # List of commands (each a list of arguments)
+    hooks_on_mention = []
+    hooks_timeout_seconds = 10
+    important_reviewers = []
+    mention_lookback_hours = 0
+    on_repo_gone = 'mark'
+    retention_deleted_days = 30
+    retention_deleted_days_by_status = {}
+    retention_hard_delete = False
+    reviewed_bring_back_after_hours = 4
+    # List of `{'name': ..., 'query': ...}`
+    saved_searches = []
+    snooze_drafts = False
+    # What happens to snoozed PRs once they get merged/closed: `surface` or `delete`
+    snoozed_on_merged_or_closed = 'surface'
+    # Server's local time if `None`
+    timezone = None
+    # Disabled if `None`
+    webhook_secret = None
+    website_template = None
+
+    def _add_render_only_fields(self, pr):
+        pr = copy.deepcopy(pr)
+        # Authorship takes precedence over any other involvement: automation may request a review from the author,
+        # but the user should never be asked to review their own PR. This is why `author_is_self` only looks at the
+        # author login and not at which search query (assigned/review-requested/...) found the PR.
+        author_is_self = is_same_login(pr['github_fields']['author']['login'], self.github_user)
+        due = pr['workboard_fields'].get('due')
+        # Only set once the user's comment went unanswered for the configured time
+        awaiting_response_desc = None
+        if self.awaiting_response_hours is not None:
+            awaiting_response_since = own_comment_awaiting_response_since(pr['github_fields'], self.github_user)
+            if (awaiting_response_since is not None
+                    and awaiting_response_since + 3600 * self.awaiting_response_hours <= time.time()):
+                awaiting_response_desc = timeago.format(
+                    datetime.datetime.fromtimestamp(awaiting_response_since), locale='en')
+        pr['render_only_fields'] = {
+            'awaiting_response_desc': awaiting_response_desc,
+            'diff_summary': diff_summary(pr['github_fields']),
+            'important_reviewer_states': important_reviewer_states(pr['github_fields'], self.important_reviewers),
+            'own_review_state': own_review_state(pr['github_fields'], self.github_user),
+            'requested_reviewers': requested_reviewers(pr['github_fields']),
+            'has_merge_conflicts': has_merge_conflicts(pr['github_fields']),
+            'merge_state_desc': merge_state_desc(pr['github_fields']),
+            'review_decision': review_decision(pr['github_fields']),
+            'due_date': (
+                datetime.datetime.fromtimestamp(due, self.timezone).date().isoformat() if due is not None else ''),
+            'author_is_self': author_is_self,
+            'is_focused': pr['workboard_fields'].get('focus_until', 0) > time.time(),
+            'is_overdue': is_overdue(pr['workboard_fields'], now=time.time()),
+            'last_activity_actor': last_activity_actor(pr['github_fields']),
+            'is_unread': is_unread(pr['github_fields'], pr['workboard_fields'], self.github_user),
+            # Latest first
+            'recent_status_history': [
+                dict(entry, at_desc=timeago.format(datetime.datetime.fromtimestamp(entry['at']), locale='en'))
+                for entry in reversed(self.db.get(STATUS_HISTORY_KEY_PREFIX + pr['github_fields']['url'], [])[-5:])
+            ],
+            'last_updated_desc': timeago.format(
+                datetime.datetime.fromtimestamp(github_datetime_to_timestamp(pr['github_fields']['updatedAt'])),
+                locale='en'),
+            # Distinguishes "haven't looked" from "commented, but review still pending"
+            'commented_without_review': commented_without_review(pr['github_fields'], self.github_user),
+            # Own PR is stuck because all requested reviewers declined or were removed
+            'no_reviewers': author_is_self and has_no_reviewers(pr['github_fields']),
+            'delegate_follow_up': (
+                pr['workboard_fields']['status'] == PullRequestStatus.DELEGATED
+                and pr['workboard_fields']['delegated_at'] + DELEGATE_FOLLOW_UP_SECONDS <= time.time()
+                and not delegate_has_reviewed(
+                    pr['github_fields'],
+                    pr['workboard_fields']['delegated_to'],
+                    pr['workboard_fields']['delegated_at'])),
+        }
+        return pr
+
+    def _cached_subprocess_check_output(self, *, cache_key, cache_duration_seconds, use_cache=True, mutate_before_store_in_cache=None, subprocess_kwargs):
+        # No cache transaction around the command since it would block other threads' fetches. If two threads run
+        # the same command, the last one simply overwrites the cached value.
+        if use_cache:
+            value = self.cache.get(cache_key)
+            if value is not None:
+                logging.debug(
+                    'Using cached value for command output of cache key %r (cache duration: %s)',
+                    cache_key,
+                    cache_duration_seconds)
+                return value
+        else:
+            logging.debug('Avoiding read from cache for cache key %r', cache_key)
+            self.cache.pop(cache_key)
+
+        # Further calls during a rate limit only make it last longer. Querying the quota is always allowed.
+        is_rate_limit_query = subprocess_kwargs['args'][1:3] == ['api', 'rate_limit']
+        rate_limited_until = self.cache.get('github-rate-limited-until')
+        if rate_limited_until is not None and not is_rate_limit_query:
+            raise GitHubRateLimitError(
+                f'GitHub rate limit exceeded, not running command for cache key {cache_key!r} until '
+                f'{datetime.datetime.fromtimestamp(rate_limited_until):%H:%M:%S}',
+                '',
+                rate_limited_until)
+
+        logging.debug('Running command for cache key %r (cache duration: %ds)', cache_key, cache_duration_seconds)
+        if self.github_host is not None:
+            # `gh` commands which don't get a URL (e.g. `gh search prs`) would otherwise talk to github.com
+            subprocess_kwargs = dict(subprocess_kwargs, env=dict(os.environ, GH_HOST=self.github_host))
+        # E.g. `pr view` or `search prs`
+        metrics_command = ' '.join(subprocess_kwargs['args'][1:3])
+
+        def run_command():
+            begin = time.perf_counter()
+            proc = subprocess.Popen(**subprocess_kwargs, stdout=subprocess.PIPE, stderr=subprocess.PIPE)
+            (stdout, stderr) = proc.communicate()
+            METRICS.observe(
+                'workboard_github_command_duration_seconds',
+                {'command': metrics_command},
+                time.perf_counter() - begin)
+            METRICS.inc(
+                'workboard_github_commands_total',
+                {'command': metrics_command, 'result': 'failure' if proc.returncode else 'success'})
+            if proc.returncode and is_rate_limit_error(stderr):
+                retry_at = time.time() + RATE_LIMIT_BACKOFF_SECONDS
+                self.cache.set('github-rate-limited-until', retry_at, expire=RATE_LIMIT_BACKOFF_SECONDS)
+                raise GitHubRateLimitError(
+                    f'GitHub rate limit exceeded for cache key {cache_key!r}, pausing GitHub calls until '
+                    f'{datetime.datetime.fromtimestamp(retry_at):%H:%M:%S}. Error output was: {stderr!r}',
+                    stderr,
+                    retry_at)
+            if proc.returncode:
+                raise GitHubCommandError(
+                    f'Command failed for cache key {cache_key!r}. Error output was: {stderr!r}', stderr)
+            return stdout
+
+        value = run_with_retries(
+            run_command,
+            self.github_max_attempts,
+            on_retry=lambda e, delay_seconds: logging.warning(
+                'Command for cache key %r failed with transient error, retrying in %.1fs: %s',
+                cache_key, delay_seconds, e.stderr.strip()))
+        if mutate_before_store_in_cache is not None:
+            value = mutate_before_store_in_cache(value)
+        if use_cache:
+            self.cache.set(cache_key, value, expire=cache_duration_seconds)
+
+        return value
+
+    def _fetch_remaining_github_pr_fields(self, github_pr, use_cache=True):
+        """
+        Since the search API doesn't support all fields, such as `merged`, we fetch those separately.
+
+        This function may be called on `gh search prs` items, but also on database items, such as PRs which are
+        merged/closed by now and therefore aren't returned by our `gh search prs` command lines. It must behave
+        reasonably on database items: those might have outdated information, so we fetch all fields again to ensure
+        everything is up to date with the actual values in GitHub.
+        """
+
+        updated_seconds_ago = abs(time.time() - github_datetime_to_timestamp(github_pr['updatedAt']))
+        # Cache for longer if last update of PR is long ago
+        if updated_seconds_ago > 86400 * 365:
+            cache_duration_seconds = 14400
+        elif updated_seconds_ago > 86400 * 7:
+            cache_duration_seconds = 3600
+        elif updated_seconds_ago > 86400 * 2:
+            cache_duration_seconds = 1800
+        else:
+            cache_duration_seconds = 600
+
+        extra_fields_json_arg = ','.join((
+            'additions',
+            'author',
+            'changedFiles',
+            'closed',
+            'comments',
+            'commits',
+            'deletions',
+            'files',
+            'isDraft',
+            'mergeStateStatus',
+            'mergeable',
+            'reviewDecision',
+            'reviewRequests',
+            'reviews',
+            'state',
+            'title',
+            'updatedAt',
+        ))
+        extra_fields = self._cached_subprocess_check_output(
+            cache_key=f'subprocess.pr.{github_pr["url"]}.{extra_fields_json_arg}',
+            cache_duration_seconds=cache_duration_seconds,
+            mutate_before_store_in_cache=lambda v: trim_github_pr_fields(json.loads(v), self.github_user),
+            use_cache=use_cache and not self.db.get(f'avoid-cache.{github_pr["url"]}'),
+            subprocess_kwargs=dict(
+                args=[
+                    'gh',
+                    'pr',
+                    'view',
+                    github_pr['url'],
+                    '--json', extra_fields_json_arg,
+                ],
+                encoding='utf-8',
+            ),
+        )
+
+        github_pr = copy.deepcopy(github_pr)
+        github_pr.update(extra_fields)
+        # Inline code comments need an extra `gh api graphql` call per PR, and are only of interest for mention
+        # detection. Without mention hooks, that only matters for PRs snoozed until mentioned. Other PRs keep the
+        # previously fetched comments, so the latest mention doesn't jump back and forth and look new.
+        stored_pr = self.db.get(PR_KEY_PREFIX + github_pr['url'])
+        if self.hooks_on_mention or (
+                stored_pr is not None
+                and stored_pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_MENTIONED):
+            github_pr['reviewThreadComments'] = self._fetch_review_thread_comments(
+                github_pr['url'], cache_duration_seconds, use_cache)
+        else:
+            github_pr['reviewThreadComments'] = (
+                [] if stored_pr is None else stored_pr['github_fields'].get('reviewThreadComments', []))
+        github_pr['snoozeLookups'] = self._fetch_snooze_lookups(github_pr, stored_pr)
+        return github_pr
+
+    def _fetch_snooze_lookups(self, github_pr, stored_pr):
+        """
+        Extra GitHub data which only PRs with certain snooze statuses need. Fetched together with the PR, so that it
+        runs concurrently and outside of database transactions, and a failure becomes a fetch error of this PR. Only
+        used for the status update, not stored.
+        """
+
+        lookups = {}
+        status = None if stored_pr is None else stored_pr['workboard_fields']['status']
+        if status == PullRequestStatus.SNOOZED_UNTIL_RELEASE:
+            lookups['release_tags'] = sorted(self._fetch_release_tags(github_pr['repository']['nameWithOwner']))
+        elif status == PullRequestStatus.SNOOZED_UNTIL_WORKFLOW:
+            lookups['checks'] = self._fetch_checks(github_pr['url'])
+        elif status == PullRequestStatus.SNOOZED_UNTIL_OTHER_MERGED:
+            other_pr_url = stored_pr['workboard_fields']['snooze_until_merged_pr_url']
+            try:
+                lookups['other_pr_state'] = self._fetch_pr_state(other_pr_url)
+            except GitHubCommandError as e:
+                # Must not be reported as error of this PR, since that would be taken for this PR being gone
+                if gone_status_for_error(e) is None:
+                    raise
+                lookups['other_pr_state'] = 'GONE'
+        return lookups
+
+    def _fetch_review_thread_comments(self, pr_url, cache_duration_seconds, use_cache):
+        # `gh pr view` doesn't offer inline code comments, so we need GraphQL. Caps keep very chatty PRs cheap.
+        owner, repo_name, _, number = pr_url.rstrip('/').split('/')[-4:]
+        query = '''
+            query($owner: String!, $name: String!, $number: Int!) {
+                repository(owner: $owner, name: $name) {
+                    pullRequest(number: $number) {
+                        reviewThreads(last: 50) {
+                            nodes { comments(last: 10) { nodes { author { login } body createdAt } } }
+                        }
+                    }
+                }
+            }
+        '''
+        return self._cached_subprocess_check_output(
+            cache_key=f'subprocess.pr.{pr_url}.review-threads',
+            cache_duration_seconds=cache_duration_seconds,
+            mutate_before_store_in_cache=lambda v: review_thread_comments(json.loads(v)),
+            use_cache=use_cache and not self.db.get(f'avoid-cache.{pr_url}'),
+            subprocess_kwargs=dict(
+                args=[
+                    'gh',
+                    'api',
+                    'graphql',
+                    '-f', f'query={query}',
+                    '-f', f'owner={owner}',
+                    '-f', f'name={repo_name}',
+                    '-F', f'number={number}',
+                ],
+                encoding='utf-8',
+            ),
+        )
+
+    def _fetch_remaining_github_pr_fields_concurrently(self, github_prs):
+        """
+        Each `gh` call takes a while, so we run several at once. Returns `(github_pr, error)` pairs in the input order,
+        with the input PR in case of an error. Storing the results is left to the calling thread.
+        """
+
+        with concurrent.futures.ThreadPoolExecutor(max_workers=self.github_fetch_concurrency) as executor:
+            futures = [
+                executor.submit(self._fetch_remaining_github_pr_fields, github_pr)
+                for github_pr in github_prs
+            ]
+        return [
+            (github_pr, future.exception()) if future.exception() is not None else (future.result(), None)
+            for github_pr, future in zip(github_prs, futures)
+        ]
+
+    def _refetch_and_store_github_pr(self, pr_url):
+        """
+        Refetch PR without reading stale value from cache.
+
+        This only refetches fields requested in `_fetch_remaining_github_pr_fields`, such as `updatedAt`!
+        """
+        # No transaction around the `gh` calls, since that would lock the database for webhook processing in the
+        # background. Storing has its own transaction.
+        github_pr = self._get_pull_request(pr_url)['github_fields']
+        try:
+            github_pr = self._fetch_remaining_github_pr_fields(github_pr, use_cache=False)
+        except GitHubCommandError as e:
+            gone_status = gone_status_for_error(e)
+            if gone_status is None:
+                raise
+            self._update_db_for_gone(pr_url, gone_status)
+            return
+        self._update_db_from_github_pr(github_pr)
+
+    def _update_db_for_gone(self, pr_url, gone_status):
+        """
+        A deleted repo or PR fails every `gh pr view` call forever, so we only do this check once and then either mark
+        the PR for manual deletion or delete it right away (`github.on_repo_gone` config). The last known GitHub fields
+        are kept for display.
+        """
+
+        with self.db.transact():
+            pr = self._get_pull_request(pr_url)
+            gone_flag = 'repo_gone' if gone_status == PullRequestStatus.REPO_GONE else 'pr_gone'
+            if not pr['workboard_fields'].get(gone_flag):
+                if gone_status == PullRequestStatus.REPO_GONE:
+                    logging.warning('Repo of PR %r was deleted or is not accessible anymore', pr_url)
+                else:
+                    logging.warning('PR %r was deleted', pr_url)
+                pr['workboard_fields'][gone_flag] = True
+
+            if pr['workboard_fields']['status'] == PullRequestStatus.DELETED:
+                if pr['workboard_fields'].get('delete_after', 0) <= time.time():
+                    logging.info('Deleting PR %r from database', pr_url)
+                    self._delete_pull_request(pr_url)
+                    return
+            elif self.on_repo_gone == 'delete':
+                logging.info('Marking PR %r as deleted because it is gone from GitHub', pr_url)
+                self._mark_deleted(pr, 'gone from GitHub')
+            elif pr['workboard_fields']['status'] != gone_status:
+                self._set_status(pr, gone_status, 'gone from GitHub')
+
+            self._store_pull_request(pr)
+
+    def _snooze_until_time(self, pr, snooze_until, reason):
+        self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_TIME, reason)
+        pr['workboard_fields']['snooze_until'] = snooze_until
+        pr['workboard_fields'].pop('snooze_after_activity_seconds', None)
+        pr['workboard_fields'].pop('snooze_until_max', None)
+
+    def _set_status(self, pr, status, reason):
+        old_status = pr['workboard_fields']['status']
+        pr['workboard_fields']['status'] = status
+        pr['workboard_fields']['last_change'] = time.time()
+
+        if status != old_status:
+            # Separate key, so that the history doesn't bloat PR listings
+            pr_url = pr['github_fields']['url']
+            self.db.set(
+                STATUS_HISTORY_KEY_PREFIX + pr_url,
+                with_status_history_entry(
+                    self.db.get(STATUS_HISTORY_KEY_PREFIX + pr_url, []),
+                    {'at': time.time(), 'from': str(old_status), 'to': str(status), 'reason': reason}))
+
+            payload = {
+                'pr_url': pr['github_fields']['url'],
+                'old_status': old_status,
+                'new_status': status,
+                'github_fields': copy.deepcopy(pr['github_fields']),
+                'workboard_fields': copy.deepcopy(pr['workboard_fields']),
+            }
+            for command in self.hooks_by_status.get(str(status), []):
+                logging.info('Running hook %r for PR %r (%s => %s)', command, payload['pr_url'], old_status, status)
+                self._run_hook_in_background(command, payload)
+
+    def _run_hook_in_background(self, command, payload):
+        def run_hook_and_log():
+            outcome = run_hook(command, payload, self.hooks_timeout_seconds)
+            if outcome != 'success':
+                logging.warning('Hook %r for PR %r failed: %s', command, payload['pr_url'], outcome)
+
+        # In the background, so that slow hooks never block storing the PR
+        threading.Thread(target=run_hook_and_log, daemon=True).start()
+
+    def _delete_by_user(self, pr):
+        # Selectable in the `?status=deleted` view, but deleting again would lose the status for restoring
+        if pr['workboard_fields']['status'] == PullRequestStatus.DELETED:
+            logging.warning('PR %r is deleted already', pr['github_fields']['url'])
+            return
+        if self.retention_hard_delete:
+            # The PR comes back if a cached or future PR listing still contains it, which is what the user asked for
+            logging.info('Deleting PR %r from database right away', pr['github_fields']['url'])
+            self._delete_pull_request(pr['github_fields']['url'])
+        else:
+            self._mark_deleted(pr, 'by user')
+            self._store_pull_request(pr)
+
+    def _mark_deleted(self, pr, reason, retention_status=None):
+        """
+        The previous status is kept so that the user can restore the PR (see `/pr/restore`).
+        """
+
+        if pr['workboard_fields']['status'] == PullRequestStatus.DELETED:
+            return
+        status_before_delete = pr['workboard_fields']['status']
+        pr['workboard_fields']['status_before_delete'] = status_before_delete
+        pr['workboard_fields']['delete_after'] = self._delete_after(retention_status or status_before_delete)
+        self._set_status(pr, PullRequestStatus.DELETED, reason)
+
+    def _delete_after(self, status_before_delete):
+        """
+        Deleted PRs stay in storage for a while since a cached "list some PRs" command output may otherwise re-add
+        them. How long depends on the status they had before deletion.
+        """
+
+        days = self.retention_deleted_days_by_status.get(str(status_before_delete), self.retention_deleted_days)
+        return time.time() + 86400 * days
+
+    def _mark_visited(self, pr_url):
+        with self.db.transact():
+            # Doesn't exist if clicked in a stale browser tab after deletion
+            if PR_KEY_PREFIX + pr_url not in self.db:
+                return
+            pr = self._get_pull_request(pr_url)
+            pr['workboard_fields']['last_visited_at'] = time.time()
+            pr['workboard_fields'].pop('marked_unread', None)
+            self._store_pull_request(pr)
+
+    def _uncache_pr(self, pr_url):
+        logging.info('Uncaching PR %r so the next few page reloads will fetch the latest updates each time', pr_url)
+
+        with self.cache.transact():
+            # Brute-force substring search, potentially matching unrelated cache keys, is good enough for the small
+            # set of data that we expect in the cache storage
+            cache_keys_to_delete = []
+            for cache_key in self.cache:
+                if pr_url in cache_key:
+                    cache_keys_to_delete.append(cache_key)
+            for cache_key in cache_keys_to_delete:
+                logging.debug('Uncaching value for key %r for PR %r', cache_key, pr_url)
+                self.cache.pop(cache_key)
+
+        self.db.set(f'avoid-cache.{pr_url}', True, expire=300)
+
+    def _update_db_from_github_pr(self, github_pr, saved_search_name=None):
+        with self.db.transact():
+            # {'github_fields': {...}, 'workboard_fields': {...}}
+            pr = self.db.get(PR_KEY_PREFIX + github_pr['url'], {})
+            pr['github_fields'] = copy.deepcopy(github_pr)
+            pr['github_fields'].pop('snoozeLookups', None)
+            self._sanitize_github_pr_fields(pr['github_fields'])
+            pr.setdefault('workboard_fields', {})
+
+            # These are the only available fields of ours if PR is inserted the first time
+            pr['workboard_fields'].setdefault('status', PullRequestStatus.UNKNOWN)
+            pr['workboard_fields'].setdefault('last_change', github_datetime_to_timestamp(github_pr['updatedAt']))
+            pr['workboard_fields']['last_refreshed_at'] = time.time()
+            if saved_search_name is not None:
+                # Remember which saved search found the PR first
+                pr['workboard_fields'].setdefault('saved_search', saved_search_name)
+
+            self._update_status_from_github_pr(pr, github_pr)
+
+            if (pr['workboard_fields']['status'] == PullRequestStatus.DELETED
+                    and pr['workboard_fields'].get('delete_after', 0) <= time.time()):
+                logging.info('Deleting PR %r from database', github_pr['url'])
+                self._delete_pull_request(github_pr['url'])
+                return
+
+            self._store_pull_request(pr)
+
+    @staticmethod
+    def _sanitize_github_pr_fields(github_pr):
+        # Anyone can create PRs with huge titles or control characters. Those would bloat storage or break rendering.
+        github_pr['title'] = sanitize_text(github_pr['title'], max_length=300)
+        github_pr['author']['login'] = sanitize_text(github_pr['author']['login'], max_length=100)
+        github_pr['repository']['nameWithOwner'] = sanitize_text(
+            github_pr['repository']['nameWithOwner'], max_length=200)
+
+    def _update_status_from_github_pr(self, pr, github_pr):
+        # See GitHub PR fields https://docs.github.com/en/graphql/reference/objects#pullrequest.
+        # If any new fields are required here, add them to our `gh search prs [...] --json` command or it won't
+        # be fetched.
+
+        if (pr['workboard_fields']['status'] not in (PullRequestStatus.DELETED, PullRequestStatus.MERGED)
+                and github_pr['state'].lower() == 'merged'
+                and github_pr['closed']):
+            if pr['workboard_fields']['status'] == PullRequestStatus.REVIEWED_DELETE_ON_MERGE:
+                logging.info('Marking PR %r as deleted because it was merged', github_pr['url'])
+                self._mark_deleted(pr, 'merged after own review', retention_status=PullRequestStatus.MERGED)
+            elif (pr['workboard_fields']['status'] in SNOOZED_PR_STATUSES
+                    and self.snoozed_on_merged_or_closed == 'delete'):
+                logging.info('Marking snoozed PR %r as deleted because it was merged', github_pr['url'])
+                self._mark_deleted(pr, 'merged while snoozed', retention_status=PullRequestStatus.MERGED)
+            else:
+                logging.info('Marking PR %r as merged', github_pr['url'])
+                self._set_status(pr, PullRequestStatus.MERGED, 'merged')
+
+        if (pr['workboard_fields']['status'] == PullRequestStatus.REVIEWED_DELETE_ON_MERGE
+                and pr['workboard_fields']['bring_back_to_review_if_not_merged_until'] <= time.time()):
+            logging.info('Passed the time until PR %r was meant to be merged, marking as must-review', github_pr['url'])
+            self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'not merged in time after own review')
+            del pr['workboard_fields']['bring_back_to_review_if_not_merged_until']
+
+        if (pr['workboard_fields']['status'] not in (PullRequestStatus.DELETED, PullRequestStatus.CLOSED)
+                and github_pr['state'].lower() == 'closed'
+                and github_pr['closed']):
+            if (pr['workboard_fields']['status'] in SNOOZED_PR_STATUSES
+                    and self.snoozed_on_merged_or_closed == 'delete'):
+                logging.info('Marking snoozed PR %r as deleted because it was closed', github_pr['url'])
+                self._mark_deleted(pr, 'closed while snoozed', retention_status=PullRequestStatus.CLOSED)
+            elif self.delete_closed_unreviewed and closed_without_own_review(github_pr, self.github_user):
+                logging.info('Marking PR %r as deleted because it was closed before you reviewed it', github_pr['url'])
+                self._mark_deleted(pr, 'closed before own review', retention_status=PullRequestStatus.CLOSED)
+            else:
+                self._set_status(pr, PullRequestStatus.CLOSED, 'closed')
+
+        if (pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_TIME
+                and pr['workboard_fields'].get('snooze_after_activity_seconds')
+                and github_pr.get('updatedAt')):
+            snooze_until = activity_based_snooze_until(
+                github_pr['updatedAt'],
+                pr['workboard_fields']['snooze_after_activity_seconds'],
+                pr['workboard_fields']['snooze_until_max'])
+            if snooze_until != pr['workboard_fields']['snooze_until']:
+                logging.info(
+                    'PR %r had new activity, moving end of snooze from %r to %r',
+                    github_pr['url'], pr['workboard_fields']['snooze_until'], snooze_until)
+                pr['workboard_fields']['snooze_until'] = snooze_until
+
+        if (pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_TIME
+                and pr['workboard_fields']['snooze_until'] <= time.time()):
+            logging.info('Passed the time until PR %r was snoozed, unsnoozing it', github_pr['url'])
+            self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'snooze time passed')
+            del pr['workboard_fields']['snooze_until']
+            pr['workboard_fields'].pop('snooze_after_activity_seconds', None)
+            pr['workboard_fields'].pop('snooze_until_max', None)
+
+        reviewed_at = re_requested_review_at(github_pr, self.github_user)
+        if (reviewed_at is not None
+                and reviewed_at != pr['workboard_fields'].get('re_requested_after_review_at')
+                and not is_same_login(github_pr['author']['login'], self.github_user)):
+            # Only react once per re-request
+            pr['workboard_fields']['re_requested_after_review_at'] = reviewed_at
+            if pr['workboard_fields']['status'] not in (
+                    PullRequestStatus.CLOSED,
+                    PullRequestStatus.DELETED,
+                    PullRequestStatus.MERGED,
+                    PullRequestStatus.MUST_REVIEW):
+                logging.info(
+                    'Review of PR %r was re-requested after own review at %r, marking as must-review',
+                    github_pr['url'], reviewed_at)
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'review re-requested after own review')
+
+        # With "dismiss stale approvals" branch protection, new commits dismiss the user's approval, so the PR can't be
+        # merged as expected after the user reviewed it
+        own_review = latest_own_review(github_pr, self.github_user)
+        if (pr['workboard_fields']['status'] == PullRequestStatus.REVIEWED_DELETE_ON_MERGE
+                and own_review is not None
+                and own_review['state'] == 'DISMISSED'
+                and own_review['submittedAt'] != pr['workboard_fields'].get('dismissed_review_handled_at')):
+            logging.info('Own review of PR %r was dismissed, marking as must-review', github_pr['url'])
+            # Only react once per dismissed review
+            pr['workboard_fields']['dismissed_review_handled_at'] = own_review['submittedAt']
+            self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'own review dismissed')
+            pr['workboard_fields'].pop('bring_back_to_review_if_not_merged_until', None)
+
+        mentioned_at = latest_mention_at(github_pr, self.github_user)
+        if is_new_mention(mentioned_at, pr['workboard_fields'], 3600 * self.mention_lookback_hours, time.time()):
+            logging.info('You were mentioned in PR %r at %r', github_pr['url'], mentioned_at)
+            if pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_MENTIONED:
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'mentioned')
+            # Once per refresh, however many new mentions there are
+            payload = {
+                'pr_url': github_pr['url'],
+                'mentioned_at': mentioned_at,
+                'github_fields': copy.deepcopy(github_pr),
+                'workboard_fields': copy.deepcopy(pr['workboard_fields']),
+            }
+            for command in self.hooks_on_mention:
+                logging.info('Running mention hook %r for PR %r', command, github_pr['url'])
+                self._run_hook_in_background(command, payload)
+        pr['workboard_fields']['last_mention_at'] = mentioned_at
+
+        # Only reacts when a PR becomes a draft, so the user can still decide to look at a draft anyway
+        if (self.snooze_drafts
+                and github_pr.get('isDraft')
+                and not pr['workboard_fields'].get('draft_seen')
+                and not is_same_login(github_pr['author']['login'], self.github_user)):
+            pr['workboard_fields']['draft_seen'] = True
+            if pr['workboard_fields']['status'] in ACTIONABLE_PR_STATUSES:
+                logging.info('Snoozing draft PR %r until it is ready for review', github_pr['url'])
+                self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_READY, 'draft PR')
+        elif github_pr.get('isDraft') is False:
+            pr['workboard_fields'].pop('draft_seen', None)
+            if pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_READY:
+                logging.info('Draft PR %r is ready for review now, marking as must-review', github_pr['url'])
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'ready for review')
+
+        # The author has to resolve conflicts, so bring a snoozed own PR back. Only reacts once per conflict.
+        if has_merge_conflicts(github_pr) and not pr['workboard_fields'].get('merge_conflict_seen'):
+            pr['workboard_fields']['merge_conflict_seen'] = True
+            if (is_same_login(github_pr['author']['login'], self.github_user)
+                    and pr['workboard_fields']['status'] in SNOOZED_PR_STATUSES + (
+                        PullRequestStatus.REVIEWED_DELETE_ON_MERGE,)):
+                logging.info('Own PR %r has merge conflicts now, marking as must-review', github_pr['url'])
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'own PR has merge conflicts')
+                pr['workboard_fields'].pop('bring_back_to_review_if_not_merged_until', None)
+        elif github_pr.get('mergeable') == 'MERGEABLE':
+            pr['workboard_fields'].pop('merge_conflict_seen', None)
+
+        if pr['workboard_fields'].get('focus_until', float('inf')) < time.time():
+            logging.info('Focus time of PR %r ended', github_pr['url'])
+            del pr['workboard_fields']['focus_until']
+
+        if pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_RELEASE:
+            release_tag = pr['workboard_fields']['snooze_until_release']
+            unsnooze_reason = None
+            if pr['workboard_fields']['snooze_until_release_max'] <= time.time():
+                unsnooze_reason = 'release did not appear in time'
+            elif release_tag in github_pr.get('snoozeLookups', {}).get('release_tags', []):
+                unsnooze_reason = 'release exists now'
+            if unsnooze_reason is not None:
+                logging.info(
+                    'Unsnoozing PR %r which waited for release %r (%s)', github_pr['url'], release_tag, unsnooze_reason)
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, f'waited for release, {unsnooze_reason}')
+                del pr['workboard_fields']['snooze_until_release']
+                del pr['workboard_fields']['snooze_until_release_max']
+
+        if pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_SMALLER:
+            max_changed_lines = pr['workboard_fields']['snooze_until_smaller_than']
+            unsnooze_reason = None
+            if is_smaller_than(github_pr, max_changed_lines):
+                unsnooze_reason = f'now below {max_changed_lines} changed lines'
+            elif pr['workboard_fields']['snooze_until_smaller_max'] <= time.time():
+                unsnooze_reason = 'did not shrink in time'
+            if unsnooze_reason is not None:
+                logging.info('Unsnoozing PR %r which waited to become smaller (%s)', github_pr['url'], unsnooze_reason)
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, f'waited to become smaller, {unsnooze_reason}')
+                del pr['workboard_fields']['snooze_until_smaller_than']
+                del pr['workboard_fields']['snooze_until_smaller_max']
+
+        if pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_WORKFLOW:
+            workflow_name = pr['workboard_fields']['snooze_until_workflow']
+            unsnooze_reason = None
+            if pr['workboard_fields']['snooze_until_workflow_max'] <= time.time():
+                unsnooze_reason = 'workflow did not complete in time'
+            elif 'checks' in github_pr.get('snoozeLookups', {}):
+                # A missing workflow (e.g. after a force-push) may still get triggered, so we keep waiting for it
+                conclusion = workflow_conclusion(github_pr['snoozeLookups']['checks'], workflow_name)
+                if conclusion not in (None, 'pending'):
+                    unsnooze_reason = f'workflow completed with conclusion {conclusion!r}'
+            if unsnooze_reason is not None:
+                logging.info(
+                    'Unsnoozing PR %r which waited for workflow %r (%s)',
+                    github_pr['url'], workflow_name, unsnooze_reason)
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, f'waited for workflow, {unsnooze_reason}')
+                del pr['workboard_fields']['snooze_until_workflow']
+                del pr['workboard_fields']['snooze_until_workflow_max']
+
+        if pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_OTHER_MERGED:
+            other_pr_url = pr['workboard_fields']['snooze_until_merged_pr_url']
+            unsnooze_reason = None
+            other_pr_state = github_pr.get('snoozeLookups', {}).get('other_pr_state')
+            if other_pr_state == 'GONE':
+                unsnooze_reason = 'other PR is gone'
+            elif other_pr_state == 'MERGED':
+                unsnooze_reason = 'other PR was merged'
+            elif other_pr_state == 'CLOSED':
+                unsnooze_reason = 'other PR was closed without merge'
+            if unsnooze_reason is not None:
+                logging.info(
+                    'Unsnoozing PR %r which waited for PR %r (%s)', github_pr['url'], other_pr_url, unsnooze_reason)
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, f'waited for other PR, {unsnooze_reason}')
+                del pr['workboard_fields']['snooze_until_merged_pr_url']
+
+        if (pr['workboard_fields']['status'] == PullRequestStatus.SNOOZED_UNTIL_UPDATE
+                and github_pr.get('updatedAt')
+                and github_pr['updatedAt'] != pr['workboard_fields']['snooze_until_updated_at_changed_from']):
+            logging.info(
+                'Snoozed PR %r was updated between %r and %r, unsnoozing it',
+                github_pr['url'], pr['workboard_fields']['snooze_until_updated_at_changed_from'], github_pr['updatedAt'])
+            self._set_status(pr, PullRequestStatus.UPDATED_AFTER_SNOOZE, 'updated while snoozed')
+            del pr['workboard_fields']['snooze_until_updated_at_changed_from']
+
+    def _fetch_checks(self, pr_url, use_cache=True):
+        # Separate call because only few PRs need it, and the check list of each PR can be long
+        return self._cached_subprocess_check_output(
+            cache_key=f'subprocess.checks.{pr_url}',
+            cache_duration_seconds=300,
+            mutate_before_store_in_cache=lambda v: json.loads(v)['statusCheckRollup'],
+            subprocess_kwargs=dict(
+                args=[
+                    'gh',
+                    'pr', 'view', pr_url,
+                    '--json', 'statusCheckRollup',
+                ],
+                encoding='utf-8',
+            ),
+            use_cache=use_cache,
+        )
+
+    def _fetch_pr_state(self, pr_url, use_cache=True):
+        # `OPEN`, `MERGED` or `CLOSED`. Works for PRs which aren't on the board.
+        return self._cached_subprocess_check_output(
+            cache_key=f'subprocess.pr-state.{pr_url}',
+            cache_duration_seconds=300,
+            mutate_before_store_in_cache=lambda v: json.loads(v)['state'],
+            subprocess_kwargs=dict(
+                args=[
+                    'gh',
+                    'pr', 'view', pr_url,
+                    '--json', 'state',
+                ],
+                encoding='utf-8',
+            ),
+            use_cache=use_cache,
+        )
+
+    def _fetch_rate_limit_quota(self):
+        # Doesn't count against the quota itself
+        try:
+            resources = self._cached_subprocess_check_output(
+                cache_key='subprocess.rate-limit',
+                cache_duration_seconds=60,
+                mutate_before_store_in_cache=lambda v: json.loads(v)['resources'],
+                subprocess_kwargs=dict(
+                    args=[
+                        'gh',
+                        'api', 'rate_limit',
+                    ],
+                    encoding='utf-8',
+                ),
+            )
+        except GitHubCommandError as e:
+            # Quota display and throttling are nice-to-have, so don't fail the board for it
+            logging.warning('Failed to fetch GitHub rate limit quota: %s', e)
+            return []
+        return rate_limit_quota(resources)
+
+    def _fetch_release_tags(self, repo_name_with_owner):
+        # Only the latest releases matter since we wait for a new one to appear
+        return {
+            release['tagName']
+            for release in self._cached_subprocess_check_output(
+                cache_key=f'subprocess.releases.{repo_name_with_owner}',
+                cache_duration_seconds=600,
+                mutate_before_store_in_cache=lambda v: json.loads(v),
+                subprocess_kwargs=dict(
+                    args=[
+                        'gh',
+                        'release', 'list',
+                        '--repo', repo_name_with_owner,
+                        '--limit', '100',
+                        '--json', 'tagName',
+                    ],
+                    encoding='utf-8',
+                ),
+            )
+        }
+
+    @staticmethod
+    def _validate_pull_request(pr):
+        # Some checks for logic errors (important until we use static typing checks)
+        assert pr['github_fields']['url'].startswith('http')
+        # `render_only_fields` not wanted in storage
+        unwanted_fields = set(pr.keys()) - {'github_fields', 'workboard_fields'}
+        assert not unwanted_fields, f'Unwanted fields in PR object: {unwanted_fields}'
+
+    def _get_pull_requests(self):
+        return get_pull_requests(self.db)
+
+    def _get_pull_request(self, pr_url):
+        return self.db[PR_KEY_PREFIX + pr_url]
+
+    def _store_pull_request(self, pr):
+        self._validate_pull_request(pr)
+        self.db.set(PR_KEY_PREFIX + pr['github_fields']['url'], pr)
+
+    def _delete_pull_request(self, pr_url):
+        self.db.pop(PR_KEY_PREFIX + pr_url)
+        self.db.pop(STATUS_HISTORY_KEY_PREFIX + pr_url)
+
+    def do_GET(self):
+        url_parts = urlsplit(self.path)
+
+        if url_parts.path == '/favicon.ico':
+            self.send_response(404)
+            self.end_headers()
+            return
+
+        if url_parts.path == '/healthz':
+            self._serve_health()
+            return
+
+        if url_parts.path == '/metrics':
+            self._serve_metrics()
+            return
+
+        if url_parts.path == '/export':
+            self._serve_export()
+            return
+
+        if url_parts.path == '/api/pull-requests' and self.api_enabled:
+            self._serve_api_pull_requests(self._parse_board_query(url_parts.query))
+            return
+
+        if url_parts.path == '/api/status-history' and self.api_enabled:
+            self._serve_api_status_history(url_parts.query)
+            return
+
+        if url_parts.path == '/api/statistics' and self.api_enabled:
+            self._serve_api_statistics()
+            return
+
+        if url_parts.path != '/':
+            raise RuntimeError(
+                f'This app has only URL paths `/`, `/export`, `/healthz`, `/metrics` and, if enabled, '
+                f'`/api/pull-requests`, `/api/statistics` and `/api/status-history` (not {self.path!r})')
+
+        board_query_string = url_parts.query
+        default_view_query = self.db.get('ui-preferences', {}).get('default_view_query')
+        # Only the plain URL shows the default view, so links with explicit query parameters (e.g. "show all") work
+        if not board_query_string and default_view_query is not None:
+            try:
+                board_query_string = normalize_default_view_query(default_view_query)
+            except ValueError as e:
+                # E.g. a status which this version doesn't know anymore
+                logging.warning('Ignoring invalid default view %r: %s', default_view_query, e)
+        board_query = self._parse_board_query(board_query_string)
+        filters = board_query['filters']
+
+        board_load_begin = time.perf_counter()
+        try:
+            already_updated_github_pr_urls = set()
+            fetch_errors = []
+            ignored_repo_patterns = self.db.get('ignored-repo-patterns', [])
+            purge_expired_deleted_pull_requests(self.db)
+
+            github_quota = self._fetch_rate_limit_quota()
+            low_github_quota = [quota for quota in github_quota if quota['low']]
+            if low_github_quota:
+                logging.warning(
+                    'GitHub API quota is almost used up (%s), showing stored data without updates',
+                    ', '.join(f'{quota["name"]}: {quota["remaining"]} left' for quota in low_github_quota))
+
+            pr_search_json_fields_arg = 'author,repository,state,updatedAt,url,title'
+
+            pr_searches = [
+                (
+                    'Own PRs',
+                    f'subprocess.prs.own.{self.github_user}.{pr_search_json_fields_arg}',
+                    dict(
+                        args=[
+                            'gh',
+                            'search', 'prs',
+                            '--author', self.github_user,
+                            '--state', 'open',
+                            '--limit', str(PR_SEARCH_LIMIT),
+                            '--json', pr_search_json_fields_arg
+                        ],
+                        encoding='utf-8',
+                    ),
+                    None,
+                ),
+                (
+                    'Assigned PRs',
+                    f'subprocess.prs.assigned.{self.github_user}.{pr_search_json_fields_arg}',
+                    dict(
+                        args=[
+                            'gh',
+                            'search', 'prs',
+                            '--assignee', self.github_user,
+                            '--state', 'open',
+                            '--limit', str(PR_SEARCH_LIMIT),
+                            '--json', pr_search_json_fields_arg
+                        ],
+                        encoding='utf-8',
+                    ),
+                    None,
+                ),
+                (
+                    'Review requested PRs',
+                    f'subprocess.prs.review-requested.{self.github_user}.{pr_search_json_fields_arg}',
+                    dict(
+                        args=[
+                            'gh',
+                            'search', 'prs',
+                            '--review-requested', self.github_user,
+                            '--state', 'open',
+                            '--limit', str(PR_SEARCH_LIMIT),
+                            '--json', pr_search_json_fields_arg
+                        ],
+                        encoding='utf-8',
+                    ),
+                    None,
+                ),
+                (
+                    'Reviewed by me PRs',
+                    f'subprocess.prs.reviewed-by-me.{self.github_user}.{pr_search_json_fields_arg}',
+                    dict(
+                        args=[
+                            'gh',
+                            'search', 'prs',
+                            '--reviewed-by', self.github_user,
+                            '--state', 'open',
+                            '--limit', str(PR_SEARCH_LIMIT),
+                            '--json', pr_search_json_fields_arg
+                        ],
+                        encoding='utf-8',
+                    ),
+                    None,
+                ),
+            ]
+            # User-defined searches come last, so that PRs also matching a built-in search aren't tagged
+            for saved_search in self.saved_searches:
+                pr_searches.append((
+                    f'Saved search {saved_search["name"]!r}',
+                    f'subprocess.prs.saved-search.{saved_search["query"]}.{pr_search_json_fields_arg}',
+                    dict(
+                        args=[
+                            'gh',
+                            'search', 'prs',
+                            '--limit', str(PR_SEARCH_LIMIT),
+                            '--json', pr_search_json_fields_arg,
+                            '--', saved_search['query'],
+                        ],
+                        encoding='utf-8',
+                    ),
+                    saved_search['name'],
+                ))
+
+            if low_github_quota:
+                pr_searches = []
+
+            for desc, cache_key, subprocess_kwargs, saved_search_name in pr_searches:
+                github_prs_to_fetch = []
+                for github_pr in timed(desc, lambda: self._cached_subprocess_check_output(
+                    cache_key=cache_key,
+                    cache_duration_seconds=600,
+                    mutate_before_store_in_cache=lambda v: json.loads(v),
+                    subprocess_kwargs=subprocess_kwargs,
+                )):
+                    # A PR can match several queries (e.g. own PR with review requested from oneself). The first
+                    # query wins, and "Own PRs" comes first, but that has no effect on `author_is_self`.
+                    if github_pr['url'] in already_updated_github_pr_urls:
+                        continue
+                    # Neither added nor updated, which saves GitHub calls for noisy repos
+                    if repo_matches_any(github_pr['repository']['nameWithOwner'], ignored_repo_patterns):
+                        continue
+                    # Only keeps noise from being imported. PRs which the user already has on the board stay there.
+                    if (PR_KEY_PREFIX + github_pr['url'] not in self.db
+                            and title_matches_any(github_pr['title'], self.exclude_title_patterns)):
+                        logging.debug('Ignoring PR %r because its title matches an exclusion pattern', github_pr['url'])
+                        continue
+                    github_prs_to_fetch.append(github_pr)
+                    already_updated_github_pr_urls.add(github_pr['url'])
+
+                fetched, search_fetch_errors = split_fetch_results(
+                    self._fetch_remaining_github_pr_fields_concurrently(github_prs_to_fetch))
+                fetch_errors.extend(search_fetch_errors)
+                for github_pr in fetched:
+                    self._update_db_from_github_pr(github_pr, saved_search_name=saved_search_name)
+
+            pull_requests_from_db = self._get_pull_requests()
+            missing_github_pr_urls = set(pull_requests_from_db.keys()) - already_updated_github_pr_urls
+            if low_github_quota:
+                missing_github_pr_urls = set()
+            github_prs_to_fetch = []
+            # Only sorted to get the same behavior every time
+            for github_pr in map(lambda pr_url: pull_requests_from_db[pr_url]['github_fields'], sorted(missing_github_pr_urls)):
+                # PR could be closed/merged or otherwise not contained in the above queries. Since it's already in the
+                # database, the user is interested in seeing updates, so we treat it like all others, of course.
+                assert github_pr['url'] not in already_updated_github_pr_urls  # we loop through `missing_github_pr_urls`
+                if repo_matches_any(github_pr['repository']['nameWithOwner'], ignored_repo_patterns):
+                    continue
+                workboard_fields = pull_requests_from_db[github_pr['url']]['workboard_fields']
+                if workboard_fields.get('repo_gone'):
+                    self._update_db_for_gone(github_pr['url'], PullRequestStatus.REPO_GONE)
+                    continue
+                if workboard_fields.get('pr_gone'):
+                    self._update_db_for_gone(github_pr['url'], PullRequestStatus.GONE)
+                    continue
+                github_prs_to_fetch.append(github_pr)
+                already_updated_github_pr_urls.add(github_pr['url'])
+
+            results = []
+            for github_pr, error in self._fetch_remaining_github_pr_fields_concurrently(github_prs_to_fetch):
+                gone_status = gone_status_for_error(error)
+                if gone_status is not None:
+                    self._update_db_for_gone(github_pr['url'], gone_status)
+                    continue
+                results.append((github_pr, error))
+            fetched, missing_fetch_errors = split_fetch_results(results)
+            fetch_errors.extend(missing_fetch_errors)
+            for github_pr in fetched:
+                self._update_db_from_github_pr(github_pr)
+            for fetch_error in fetch_errors:
+                logging.warning('Failed to update PR %r, showing last known data: %s',
+                                fetch_error['pr_url'], fetch_error['error'])
+
+            pull_requests_to_render = sort_pull_requests(
+                map(
+                    self._add_render_only_fields,
+                    filter(
+                        lambda pr: pull_request_matches_filters(pr, filters, ignored_repo_patterns),
+                        pull_requests_from_db.values(),
+                    ),
+                ),
+                sort_by=board_query['sort'],
+                reverse=board_query['reverse'],
+            )
+
+            next_page_url = None
+            if board_query['page_size'] is not None:
+                pull_requests_to_render, next_after_pr_url = paginate(
+                    pull_requests_to_render, board_query['page_size'], board_query['after'])
+                if next_after_pr_url is not None:
+                    next_page_url = '/?' + urlencode(dict(
+                        self._get_board_query_params(board_query), after=next_after_pr_url))
+
+            csrf_token = ''.join(random.choice(string.ascii_letters + string.digits) for _ in range(100))
+            self.cache.add(f'csrf.{csrf_token}', True, 14400)
+
+            data = {
+                'bulk_snooze_skipped': self.db.pop('bulk-snooze-skipped', default=[]),
+                'csrf_token': csrf_token,
+                'fetch_errors': fetch_errors,
+                'github_quota': [
+                    dict(quota, reset_desc=f'{datetime.datetime.fromtimestamp(quota["reset"], self.timezone):%H:%M}')
+                    for quota in github_quota
+                ],
+                'low_github_quota': bool(low_github_quota),
+                'server_time_desc': f'{datetime.datetime.now(self.timezone):%a %Y-%m-%d %H:%M %Z}',
+                'filters': filters,
+                'sort_options': self._get_sort_options(board_query),
+                'github_user': self.github_user,
+                'last_clicked_github_pr_url': self.db.get('last-clicked-github-pr-url'),
+                'next_page_url': next_page_url,
+                'current_view_query': urlencode(self._get_board_query_params(board_query)),
+                'has_default_view': default_view_query is not None,
+                'ignored_repo_patterns': ignored_repo_patterns,
+                'show_all_url': '/?' + urlencode(self._get_board_query_params(dict(board_query, filters={}))),
+                'pull_requests': pull_requests_to_render,
+            }
+            res = self.website_template.render(data, undefined=jinja2.StrictUndefined).encode('utf-8')
+
+            self.send_response(200)
+            self.send_header('Content-Type', 'text/html; charset=utf-8')
+            self.end_headers()
+            self.wfile.write(res)
+            METRICS.inc('workboard_board_loads_total', {'result': 'success'})
+            METRICS.observe('workboard_board_load_duration_seconds', {}, time.perf_counter() - board_load_begin)
+        except Exception:
+            METRICS.inc('workboard_board_loads_total', {'result': 'failure'})
+            self.send_response(500)
+            self.send_header('Content-Type', 'text/html; charset=utf-8')
+            self.end_headers()
+
+            self.wfile.write(f'''
+                <html><body>
+                <h1 style="color: red">Error</h1>
+                <pre>{html.escape(traceback.format_exc())}
+                </pre>
+                </body></html>
+            '''.encode('utf-8'))
+
+    @staticmethod
+    def _parse_board_query(query_string):
+        board_query = {
+            'after': None,
+            'filters': {},
+            'page_size': None,
+            'sort': 'priority',
+            'reverse': False,
+        }
+        for key, values in parse_qs(query_string).items():
+            if len(values) != 1 or len(values[0]) > 300:
+                raise ValueError(f'Invalid value for query parameter {key!r}')
+            value = values[0]
+            if key == 'after':
+                board_query['after'] = value
+            elif key == 'page_size':
+                board_query['page_size'] = int(value)
+                if not 1 <= board_query['page_size'] <= 500:
+                    raise ValueError('Invalid page_size')
+            elif key == 'sort':
+                if value not in BOARD_SORT_OPTIONS:
+                    raise ValueError(f'Invalid sort option {value!r}')
+                board_query['sort'] = value
+            elif key == 'reverse':
+                board_query['reverse'] = value == '1'
+            elif key == 'status':
+                if not set(value.split(',')) <= set(PullRequestStatus):
+                    raise ValueError(f'Invalid status filter {value!r}')
+                board_query['filters'][key] = value
+            elif key in ('author', 'repo'):
+                board_query['filters'][key] = value
+            elif key == 'label':
+                board_query['filters'][key] = normalize_label(value)
+            else:
+                raise ValueError(f'Unknown query parameter {key!r}')
+        if board_query['after'] is not None and board_query['page_size'] is None:
+            raise ValueError('Query parameter `after` requires `page_size`')
+        return board_query
+
+    @staticmethod
+    def _get_board_query_params(board_query):
+        """
+        Query parameters to link to the first page of the current board view.
+        """
+
+        params = dict(board_query['filters'], sort=board_query['sort'])
+        if board_query['reverse']:
+            params['reverse'] = '1'
+        if board_query['page_size'] is not None:
+            params['page_size'] = str(board_query['page_size'])
+        return params
+
+    @staticmethod
+    def _get_sort_options(board_query):
+        sort_options = []
+        for sort_by, (label, _) in BOARD_SORT_OPTIONS.items():
+            active = sort_by == board_query['sort']
+            query = ServerHandler._get_board_query_params(board_query)
+            query['sort'] = sort_by
+            query.pop('reverse', None)
+            # Clicking the active option again reverses the order
+            if active and not board_query['reverse']:
+                query['reverse'] = '1'
+            sort_options.append({
+                'label': label,
+                'url': '/?' + urlencode(query),
+                'active': active,
+                'reverse': board_query['reverse'],
+            })
+        return sort_options
+
+    def _serve_health(self):
+        # Cheap check which doesn't talk to GitHub, suitable for frequent probes
+        try:
+            self.db.get('initialized')
+            status, body = 200, 'ok'
+        except Exception:  # pylint: disable=broad-exception-caught
+            logging.exception('Health check failed')
+            status, body = 503, 'database unavailable'
+
+        self.send_response(status)
+        self.send_header('Content-Type', 'text/plain; charset=utf-8')
+        self.end_headers()
+        self.wfile.write(f'{body}\n'.encode('utf-8'))
+
+    def _serve_metrics(self):
+        pull_requests = list(self._get_pull_requests().values())
+        status_counts = {str(status): 0 for status in PullRequestStatus}
+        for pr in pull_requests:
+            status_counts[pr['workboard_fields']['status']] += 1
+
+        res = METRICS.render(gauges=[
+            ('workboard_pull_requests', {'status': status}, count)
+            for status, count in sorted(status_counts.items())
+        ] + [
+            ('workboard_pull_requests_by_repo', {'repo': repo, 'status': status}, count)
+            for (repo, status), count in sorted(per_repo_status_counts(pull_requests, METRICS_MAX_REPOS).items())
+        ]).encode('utf-8')
+
+        self.send_response(200)
+        self.send_header('Content-Type', 'text/plain; version=0.0.4; charset=utf-8')
+        self.end_headers()
+        self.wfile.write(res)
+
+    def _serve_api_statistics(self):
+        # Ignored repos are left out like on the board
+        ignored_repo_patterns = self.db.get('ignored-repo-patterns', [])
+        res = json.dumps(pull_request_statistics(
+            (
+                pr
+                for pr in self._get_pull_requests().values()
+                if not repo_matches_any(pr['github_fields']['repository']['nameWithOwner'], ignored_repo_patterns)
+            ),
+            now=time.time(),
+        )).encode('utf-8')
+
+        self.send_response(200)
+        self.send_header('Content-Type', 'application/json')
+        self.end_headers()
+        self.wfile.write(res)
+
+    def _serve_api_status_history(self, query_string):
+        params = parse_qs(query_string)
+        pr_urls = params.pop('pr_url', [])
+        if params or len(pr_urls) != 1 or len(pr_urls[0]) > 300:
+            raise ValueError('Expected exactly one query parameter `pr_url`')
+        pr_url = pr_urls[0]
+
+        res = json.dumps({
+            'pr_url': pr_url,
+            # Oldest first
+            'status_history': self.db.get(STATUS_HISTORY_KEY_PREFIX + pr_url, []),
+        }).encode('utf-8')
+
+        self.send_response(200)
+        self.send_header('Content-Type', 'application/json')
+        self.end_headers()
+        self.wfile.write(res)
+
+    def _serve_api_pull_requests(self, board_query):
+        """
+        JSON listing for scripts, supporting the same query parameters as the board (filters, sort, pagination).
+
+        Uses the stored data as of the last page reload, so no GitHub requests are made here.
+        """
+
+        pull_requests = sort_pull_requests(
+            filter(
+                lambda pr: pull_request_matches_filters(
+                    pr, board_query['filters'], self.db.get('ignored-repo-patterns', [])),
+                self._get_pull_requests().values(),
+            ),
+            sort_by=board_query['sort'],
+            reverse=board_query['reverse'],
+        )
+        next_after_pr_url = None
+        if board_query['page_size'] is not None:
+            pull_requests, next_after_pr_url = paginate(
+                pull_requests, board_query['page_size'], board_query['after'])
+
+        res = json.dumps({
+            'pull_requests': pull_requests,
+            # Pass as `after` query parameter to get the next page
+            'next_after': next_after_pr_url,
+        }).encode('utf-8')
+
+        self.send_response(200)
+        self.send_header('Content-Type', 'application/json')
+        self.end_headers()
+        self.wfile.write(res)
+
+    def _serve_export(self):
+        """
+        Backup of the whole database as JSON file, including expiry times and the status history of each PR. The
+        cache isn't included since it can always be refetched.
+        """
+
+        entries = []
+        with self.db.transact():
+            for key in sorted(self.db):
+                value, expire_time = self.db.get(key, expire_time=True)
+                # Expired in the meantime
+                if value is None:
+                    continue
+                entries.append({'key': key, 'value': value, 'expire_time': expire_time})
+
+        res = json.dumps({'format': 'workboard-export', 'version': 1, 'entries': entries}, indent=2).encode('utf-8')
+
+        self.send_response(200)
+        self.send_header('Content-Type', 'application/json')
+        self.send_header(
+            'Content-Disposition',
+            f'attachment; filename="workboard-export-{datetime.date.today().isoformat()}.json"')
+        self.end_headers()
+        self.wfile.write(res)
+
+    def _serve_next_pull_request(self):
+        """
+        Review queue: redirect to the single most important PR, then to the next one on the following request.
+
+        Uses the stored data as of the last page reload, so no GitHub requests are made here. This changes state (queue,
+        last clicked and visited PR), so it must only be reached through a CSRF-protected POST request.
+        """
+
+        with self.db.transact():
+            served_pr_urls = self.db.get('review-queue-served-pr-urls', [])
+            excluded_pr_urls = set(served_pr_urls)
+            last_clicked_github_pr_url = self.db.get('last-clicked-github-pr-url')
+            if last_clicked_github_pr_url:
+                excluded_pr_urls.add(last_clicked_github_pr_url)
+
+            ignored_repo_patterns = self.db.get('ignored-repo-patterns', [])
+            pull_requests = {
+                pr_url: pr
+                for pr_url, pr in self._get_pull_requests().items()
+                if not repo_matches_any(pr['github_fields']['repository']['nameWithOwner'], ignored_repo_patterns)
+            }
+            pr_url = next_pull_request_url(pull_requests, excluded_pr_urls)
+            if pr_url is not None:
+                # Queue "session" ends once the user stops using it for a while
+                self.db.set('review-queue-served-pr-urls', served_pr_urls + [pr_url], expire=3600 * 4)
+
+        if pr_url is None:
+            logging.info('Review queue is empty, redirecting to board')
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+            return
+
+        logging.info('Serving PR %r from review queue', pr_url)
+        self._uncache_pr(pr_url)
+        self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+        self._mark_visited(pr_url)
+
+        self.send_response(303)
+        self.send_header('Location', pr_url)
+        self.end_headers()
+
+    def _get_protected_post_params(self, multi_value_keys=()):
+        """
+        Keys in `multi_value_keys` (e.g. checkboxes) map to a list of all submitted values.
+        """
+
+        pairs = parse_qsl(self.rfile.read(int(self.headers['Content-Length'])).decode('ascii'))
+        params = {key: value for key, value in pairs if key not in multi_value_keys}
+        for multi_value_key in multi_value_keys:
+            params[multi_value_key] = [value for key, value in pairs if key == multi_value_key]
+        if len(params['csrf_token']) != 100:
+            raise RuntimeError('Invalid or expired CSRF token (could be an attack)')
+        if not self.cache.get(f'csrf.{params["csrf_token"]}'):
+            raise RuntimeError('Invalid or expired CSRF token (could be an attack)')
+        return params
+
+    def _handle_github_webhook(self):
+        """
+        Push-based updates, so that PRs don't have to wait for the next board reload. GitHub must be configured to
+        send `pull_request`, `pull_request_review` and `issue_comment` events as JSON.
+        """
+
+        content_length = int(self.headers['Content-Length'])
+        if content_length > MAX_WEBHOOK_BODY_BYTES:
+            raise ValueError('Webhook payload too large')
+        body = self.rfile.read(content_length)
+
+        if not is_valid_webhook_signature(self.webhook_secret, body, self.headers.get('X-Hub-Signature-256')):
+            logging.warning('Rejecting webhook delivery with invalid signature')
+            self.send_response(401)
+            self.end_headers()
+            return
+
+        event = self.headers.get('X-GitHub-Event', '')
+        delivery_id = self.headers.get('X-GitHub-Delivery', '')
+        if not delivery_id or len(delivery_id) > 100:
+            raise ValueError('Invalid X-GitHub-Delivery header')
+
+        # GitHub retries deliveries, and users can redeliver them manually
+        if not self.cache.add(f'webhook-delivery.{delivery_id}', True, expire=86400 * 3):
+            logging.debug('Ignoring duplicate webhook delivery %r', delivery_id)
+            result = 'duplicate'
+        else:
+            result = self._process_github_webhook_event(event, json.loads(body))
+
+        self.send_response(200)
+        self.send_header('Content-Type', 'text/plain; charset=utf-8')
+        self.end_headers()
+        self.wfile.write(f'{result}\n'.encode('utf-8'))
+
+    def _process_github_webhook_event(self, event, payload):
+        github_pr_and_involved_logins = github_pr_from_webhook(event, payload)
+        if github_pr_and_involved_logins is None:
+            logging.debug('Ignoring webhook event %r', event)
+            return 'ignored'
+        github_pr, involved_logins = github_pr_and_involved_logins
+
+        if PR_KEY_PREFIX + github_pr['url'] in self.db:
+            logging.info('Refreshing PR %r because of webhook event %r', github_pr['url'], event)
+            self._run_webhook_update_in_background(
+                github_pr['url'], event, lambda: self._refetch_and_store_github_pr(github_pr['url']))
+            return 'refreshing'
+
+        if repo_matches_any(github_pr['repository']['nameWithOwner'], self.db.get('ignored-repo-patterns', [])):
+            logging.debug('Ignoring webhook event %r for PR %r in ignored repo', event, github_pr['url'])
+            return 'ignored'
+        if not any(is_same_login(login, self.github_user) for login in involved_logins):
+            logging.debug('Ignoring webhook event %r for PR %r which doesn\'t involve you', event, github_pr['url'])
+            return 'ignored'
+        if title_matches_any(github_pr['title'], self.exclude_title_patterns):
+            logging.debug('Ignoring PR %r because its title matches an exclusion pattern', github_pr['url'])
+            return 'ignored'
+
+        logging.info('Adding PR %r because of webhook event %r', github_pr['url'], event)
+        self._run_webhook_update_in_background(
+            github_pr['url'],
+            event,
+            lambda: self._update_db_from_github_pr(self._fetch_remaining_github_pr_fields(github_pr, use_cache=False)))
+        return 'adding'
+
+    def _run_webhook_update_in_background(self, pr_url, event, update):
+        def update_and_log():
+            try:
+                update()
+            except Exception:
+                # GitHub got its response already, so the next board reload has to catch up
+                logging.exception('Failed to update PR %r for webhook event %r', pr_url, event)
+
+        # In the background, since `gh` calls would block the single-threaded server and may exceed GitHub's webhook
+        # timeout of 10 seconds, which leads to redeliveries
+        threading.Thread(target=update_and_log, daemon=True).start()
+
+    def do_POST(self):
+        if self.path == '/webhook/github' and self.webhook_secret is not None:
+            self._handle_github_webhook()
+
+        elif self.path == '/next':
+            self._get_protected_post_params()
+            self._serve_next_pull_request()
+        elif self.path == '/pr/clicked':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            self._uncache_pr(pr_url)
+            self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+            self._mark_visited(pr_url)
+
+            self.send_response(204)
+            self.end_headers()
+
+        elif self.path == '/pr/mark-unread':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                pr['workboard_fields']['marked_unread'] = True
+                self._store_pull_request(pr)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+
+        elif self.path == '/pr/add':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 1000:
+                raise ValueError('Invalid pr_url')
+            pr_url, repository = parse_pull_request_url(pr_url, self.github_host)
+
+            if PR_KEY_PREFIX + pr_url in self.db:
+                if self._get_pull_request(pr_url)['workboard_fields']['status'] == PullRequestStatus.DELETED:
+                    raise ValueError(f'PR {pr_url!r} was deleted from the board, restore it instead')
+                raise ValueError(f'PR {pr_url!r} is already on the board')
+            if repo_matches_any(repository['nameWithOwner'], self.db.get('ignored-repo-patterns', [])):
+                raise ValueError(
+                    f'Repo {repository["nameWithOwner"]!r} is ignored, remove it from the ignored repos first')
+
+            logging.info('Adding PR %r manually', pr_url)
+            github_pr = {
+                'url': pr_url,
+                'repository': repository,
+                # Overwritten by the fetched value
+                'updatedAt': datetime.datetime.now(datetime.timezone.utc).strftime('%Y-%m-%dT%H:%M:%SZ'),
+            }
+            try:
+                github_pr = self._fetch_remaining_github_pr_fields(github_pr, use_cache=False)
+            except GitHubCommandError as e:
+                if gone_status_for_error(e) is None:
+                    raise
+                raise ValueError(f'PR {pr_url!r} does not exist or its repo is not accessible') from e
+            self._update_db_from_github_pr(github_pr)
+            self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+
+        elif self.path == '/repo/refresh':
+            params = self._get_protected_post_params()
+
+            repo_name_with_owner = params['repo']
+            if (not isinstance(repo_name_with_owner, str)
+                    or len(repo_name_with_owner) > 200
+                    or repo_name_with_owner.count('/') != 1):
+                raise ValueError('Invalid repo')
+
+            pr_urls = pull_request_urls_in_repo(self._get_pull_requests(), repo_name_with_owner)
+            logging.info('Refreshing %d PR(s) of repo %r', len(pr_urls), repo_name_with_owner)
+            for pr_url in pr_urls:
+                self._uncache_pr(pr_url)
+
+            # Back to homepage, which fetches the uncached PRs again
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+
+        elif self.path == '/repo/snooze-until-release':
+            params = self._get_protected_post_params()
+
+            repo_name_with_owner = params['repo']
+            if (not isinstance(repo_name_with_owner, str)
+                    or len(repo_name_with_owner) > 200
+                    or repo_name_with_owner.count('/') != 1):
+                raise ValueError('Invalid repo')
+
+            release_tag = params.get('release_tag', '').strip()
+            if not release_tag or len(release_tag) > 100 or any(c.isspace() for c in release_tag):
+                raise ValueError('Invalid release_tag')
+
+            if release_tag in self._fetch_release_tags(repo_name_with_owner):
+                raise ValueError(f'Release {release_tag!r} already exists in repo {repo_name_with_owner!r}')
+
+            with self.db.transact():
+                pull_requests = self._get_pull_requests()
+                pr_urls = [
+                    pr_url
+                    for pr_url in pull_request_urls_in_repo(pull_requests, repo_name_with_owner)
+                    if pull_requests[pr_url]['workboard_fields']['status'] not in (
+                        PullRequestStatus.CLOSED, PullRequestStatus.DELETED, PullRequestStatus.MERGED)
+                ]
+                logging.info(
+                    'Snoozing %d PR(s) of repo %r until release %r exists',
+                    len(pr_urls), repo_name_with_owner, release_tag)
+                for pr_url in pr_urls:
+                    pr = pull_requests[pr_url]
+                    self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_RELEASE, 'by user')
+                    pr['workboard_fields']['snooze_until_release'] = release_tag
+                    pr['workboard_fields']['snooze_until_release_max'] = time.time() + SNOOZE_UNTIL_RELEASE_MAX_SECONDS
+                    self._store_pull_request(pr)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+
+        elif self.path == '/pr/delete':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            logging.info('Marking PR %r as deleted', pr_url)
+
+            with self.db.transact():
+                # We cannot simply remove the PR from storage since a cached "list some PRs" command output
+                # may re-add it. Instead, we simply update the status and remove the entry eventually.
+                pr = self.db.get(PR_KEY_PREFIX + pr_url)
+                if pr is None:
+                    raise ValueError('PR not found, thus cannot be deleted')
+
+                self._delete_by_user(pr)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/preferences/default-view':
+            params = self._get_protected_post_params()
+
+            query = params['query']
+            if not isinstance(query, str):
+                raise ValueError('Invalid query')
+            default_view_query = normalize_default_view_query(query)
+
+            logging.info('Setting default view to %r', default_view_query)
+            with self.db.transact():
+                ui_preferences = self.db.get('ui-preferences', {})
+                ui_preferences['default_view_query'] = default_view_query
+                self.db.set('ui-preferences', ui_preferences)
+
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/preferences/ignored-repos':
+            params = self._get_protected_post_params()
+
+            patterns = params.get('patterns', '')
+            if not isinstance(patterns, str) or len(patterns) > 20000:
+                raise ValueError('Invalid patterns')
+            ignored_repo_patterns = parse_ignored_repo_patterns(patterns)
+
+            logging.info('Setting ignored repos to %r', ignored_repo_patterns)
+            self.db.set('ignored-repo-patterns', ignored_repo_patterns)
+
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/preferences/reset-default-view':
+            self._get_protected_post_params()
+
+            logging.info('Resetting default view')
+            with self.db.transact():
+                ui_preferences = self.db.get('ui-preferences', {})
+                ui_preferences.pop('default_view_query', None)
+                self.db.set('ui-preferences', ui_preferences)
+
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/delete-many':
+            params = self._get_protected_post_params(multi_value_keys=('pr_url',))
+
+            pr_urls = params['pr_url']
+            if len(pr_urls) > PR_SEARCH_LIMIT or any(len(pr_url) > 300 for pr_url in pr_urls):
+                raise ValueError('Invalid pr_url')
+
+            logging.info('Marking %d PR(s) as deleted', len(pr_urls))
+
+            with self.db.transact():
+                for pr_url in pr_urls:
+                    # Another browser tab may have deleted it for good already. That shouldn't fail the others.
+                    pr = self.db.get(PR_KEY_PREFIX + pr_url)
+                    if pr is None:
+                        logging.warning('PR %r not found, thus cannot be deleted', pr_url)
+                        continue
+
+                    self._delete_by_user(pr)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/restore':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                if pr['workboard_fields']['status'] != PullRequestStatus.DELETED:
+                    raise ValueError('Only deleted PRs can be restored')
+
+                status = restored_status(pr['workboard_fields'], pr['github_fields']['state'])
+                logging.info('Restoring deleted PR %r with status %r', pr_url, status)
+                self._set_status(pr, status, 'restored by user')
+                pr['workboard_fields'].pop('delete_after', None)
+                pr['workboard_fields'].pop('status_before_delete', None)
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/mark-must-review':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            logging.info('Marking PR %r as must-review', pr_url)
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                self._set_status(pr, PullRequestStatus.MUST_REVIEW, 'by user')
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/reviewed-delete-on-merge':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            logging.info('Marking PR %r as reviewed-delete-on-merge', pr_url)
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                self._set_status(pr, PullRequestStatus.REVIEWED_DELETE_ON_MERGE, 'by user')
+                pr['workboard_fields']['bring_back_to_review_if_not_merged_until'] = (
+                    time.time() + 3600 * self.reviewed_bring_back_after_hours)
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/focus':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            # 0 hours = end focus
+            hours = int(params['hours'])
+            if not 0 <= hours <= 24:
+                raise ValueError('Invalid hours')
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                if hours:
+                    logging.info('Focusing PR %r for %d hour(s)', pr_url, hours)
+                    pr['workboard_fields']['focus_until'] = time.time() + 3600 * hours
+                else:
+                    logging.info('Ending focus of PR %r', pr_url)
+                    pr['workboard_fields'].pop('focus_until', None)
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/set-due-date':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            # Format `2024-01-31` from `<input type="date">`, empty to clear
+            due_date = params.get('due_date', '').strip()
+            due = None
+            if due_date:
+                # Due at the end of the day in the configured time zone
+                due = datetime.datetime.combine(
+                    datetime.date.fromisoformat(due_date), datetime.time(23, 59, 59), tzinfo=self.timezone).timestamp()
+                if due <= time.time():
+                    raise ValueError('Due date must be in the future')
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                if due is None:
+                    logging.info('Clearing due date of PR %r', pr_url)
+                    pr['workboard_fields'].pop('due', None)
+                else:
+                    logging.info('Setting due date of PR %r to %s', pr_url, due_date)
+                    pr['workboard_fields']['due'] = due
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/set-external-url':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
 
-            csrf_token = ''.join(random.choice(string.ascii_letters + string.digits) for _ in range(100))
-            self.cache.add(f'csrf.{csrf_token}', True, 14400)
+            external_url = validate_external_url(params.get('external_url', ''))
+            external_url_label = params.get('external_url_label', '').strip()
+            if len(external_url_label) > 100:
+                raise ValueError('Invalid external_url_label')
 
-            data = {
-                'csrf_token': csrf_token,
-                'github_user': self.github_user,
-                'last_clicked_github_pr_url': self.db.get('last-clicked-github-pr-url'),
-                'pull_requests': pull_requests_to_render,
-            }
-            res = self.website_template.render(data, undefined=jinja2.StrictUndefined).encode('utf-8')
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                if external_url is None:
+                    logging.info('Clearing external URL of PR %r', pr_url)
+                    pr['workboard_fields'].pop('external_url', None)
+                    pr['workboard_fields'].pop('external_url_label', None)
+                else:
+                    logging.info('Setting external URL of PR %r to %r', pr_url, external_url)
+                    pr['workboard_fields']['external_url'] = external_url
+                    pr['workboard_fields']['external_url_label'] = external_url_label or external_url
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
 
-            self.send_response(200)
-            self.send_header('Content-Type', 'text/html; charset=utf-8')
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
             self.end_headers()
-            self.wfile.write(res)
-        except Exception:
-            self.send_response(500)
-            self.send_header('Content-Type', 'text/html; charset=utf-8')
+        elif self.path == '/pr/set-note':
+            params = self._get_protected_post_params()
+
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            note = params.get('note', '')
+            if not isinstance(note, str) or len(note) > MAX_NOTE_LENGTH:
+                raise ValueError('Invalid note')
+            note = sanitize_text(note, max_length=MAX_NOTE_LENGTH)
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                if note:
+                    logging.info('Setting note of PR %r', pr_url)
+                    pr['workboard_fields']['note'] = note
+                else:
+                    logging.info('Clearing note of PR %r', pr_url)
+                    pr['workboard_fields'].pop('note', None)
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
             self.end_headers()
+        elif self.path in ('/pr/add-label', '/pr/remove-label'):
+            params = self._get_protected_post_params()
 
-            self.wfile.write(f'''
-                <html><body>
-                <h1 style="color: red">Error</h1>
-                <pre>{html.escape(traceback.format_exc())}
-                </pre>
-                </body></html>
-            '''.encode('utf-8'))
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
 
-    def _get_protected_post_params(self):
-        params = dict(parse_qsl(self.rfile.read(int(self.headers['Content-Length'])).decode('ascii')))
-        if len(params['csrf_token']) != 100:
-            raise RuntimeError('Invalid or expired CSRF token (could be an attack)')
-        if not self.cache.get(f'csrf.{params["csrf_token"]}'):
-            raise RuntimeError('Invalid or expired CSRF token (could be an attack)')
-        return params
+            label = params['label']
+            if not isinstance(label, str):
+                raise ValueError('Invalid label')
+            label = normalize_label(label)
 
-    def do_POST(self):
-        if self.path == '/pr/clicked':
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                labels = pr['workboard_fields'].get('labels', [])
+                if self.path == '/pr/add-label':
+                    logging.info('Adding label %r to PR %r', label, pr_url)
+                    labels = with_label(labels, label)
+                else:
+                    logging.info('Removing label %r from PR %r', label, pr_url)
+                    labels = without_label(labels, label)
+                if labels:
+                    pr['workboard_fields']['labels'] = labels
+                else:
+                    pr['workboard_fields'].pop('labels', None)
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/delegate':
             params = self._get_protected_post_params()
 
             pr_url = params['pr_url']
             if not isinstance(pr_url, str) or len(pr_url) > 300:
                 raise ValueError('Invalid pr_url')
 
-            logging.info('Uncaching PR %r so the next few page reloads will fetch the latest updates each time', pr_url)
+            delegated_to = params.get('delegated_to', '').strip().removeprefix('@')
+            if not re.fullmatch(r'[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})', delegated_to):
+                raise ValueError('Invalid GitHub login for delegated_to')
 
-            with self.cache.transact():
-                # Brute-force substring search, potentially matching unrelated cache keys, is good enough for the small
-                # set of data that we expect in the cache storage
-                cache_keys_to_delete = []
-                for cache_key in self.cache:
-                    if pr_url in cache_key:
-                        cache_keys_to_delete.append(cache_key)
-                for cache_key in cache_keys_to_delete:
-                    logging.debug('Uncaching value for key %r for PR %r', cache_key, pr_url)
-                    self.cache.pop(cache_key)
+            logging.info('Marking PR %r as delegated to %r', pr_url, delegated_to)
 
             with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                self._set_status(pr, PullRequestStatus.DELEGATED, 'by user')
+                pr['workboard_fields']['delegated_to'] = delegated_to
+                pr['workboard_fields']['delegated_at'] = time.time()
+                self._store_pull_request(pr)
                 self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
-                self.db.set(f'avoid-cache.{pr_url}', True, expire=300)
 
-            self.send_response(204)
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
             self.end_headers()
+        elif self.path == '/pr/snooze-until-mentioned':
+            params = self._get_protected_post_params()
 
-        elif self.path == '/pr/delete':
+            pr_url = params['pr_url']
+            if not isinstance(pr_url, str) or len(pr_url) > 300:
+                raise ValueError('Invalid pr_url')
+
+            logging.info('Snoozing PR %r until user is mentioned', pr_url)
+
+            with self.db.transact():
+                pr = self._get_pull_request(pr_url)
+                self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_MENTIONED, 'by user')
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
+
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/snooze-until-time':
             params = self._get_protected_post_params()
 
             pr_url = params['pr_url']
             if not isinstance(pr_url, str) or len(pr_url) > 300:
                 raise ValueError('Invalid pr_url')
 
-            logging.info('Marking PR %r as deleted', pr_url)
+            until = params.get('until', '1d')
+            if not isinstance(until, str):
+                raise ValueError('Invalid until')
+            snooze_until = snooze_preset_until(until, time.time(), tz=self.timezone)
+            if snooze_until <= time.time() + 60:
+                raise ValueError('Snooze end must be in the future')
+
+            logging.info(
+                'Snoozing PR %r until %s', pr_url, datetime.datetime.fromtimestamp(snooze_until, self.timezone))
 
             with self.db.transact():
-                pull_requests = self.db['pull_requests']
+                pr = self._get_pull_request(pr_url)
+                self._snooze_until_time(pr, snooze_until, 'by user')
+                self._store_pull_request(pr)
+                self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
 
-                # We cannot simply remove the PR from `pull_requests` since a cached "list some PRs" command output
-                # may re-add it. Instead, we simply update the status and remove the entry eventually.
-                if pr_url not in pull_requests:
-                    raise ValueError('PR not found, thus cannot be deleted')
+            # Back to homepage (full reload - yes this is a very simple web app!)
+            self.send_response(303)
+            self.send_header('Location', '/')
+            self.end_headers()
+        elif self.path == '/pr/snooze-many':
+            params = self._get_protected_post_params(multi_value_keys=('pr_url',))
+
+            pr_urls = params['pr_url']
+            if len(pr_urls) > PR_SEARCH_LIMIT or any(len(pr_url) > 300 for pr_url in pr_urls):
+                raise ValueError('Invalid pr_url')
+
+            until = params.get('until', '1d')
+            if not isinstance(until, str):
+                raise ValueError('Invalid until')
+            snooze_until = snooze_preset_until(until, time.time(), tz=self.timezone)
+            if snooze_until <= time.time() + 60:
+                raise ValueError('Snooze end must be in the future')
+
+            logging.info(
+                'Snoozing %d PR(s) until %s',
+                len(pr_urls), datetime.datetime.fromtimestamp(snooze_until, self.timezone))
 
-                pr = pull_requests[pr_url]
-                pr['workboard_fields']['status'] = PullRequestStatus.DELETED
-                pr['workboard_fields']['last_change'] = time.time()
-                pr['workboard_fields']['delete_after'] = time.time() + 86400 * 30
-                self._validate_pull_requests(pull_requests)
-                self.db.set('pull_requests', pull_requests)
+            skipped = []
+            with self.db.transact():
+                for pr_url in pr_urls:
+                    # Another browser tab may have deleted it for good already. That shouldn't fail the others.
+                    pr = self.db.get(PR_KEY_PREFIX + pr_url)
+                    if pr is None:
+                        logging.warning('PR %r not found, thus cannot be snoozed', pr_url)
+                        skipped.append({'pr_url': pr_url, 'reason': 'not found'})
+                        continue
+                    # Selectable in the `?status=deleted` view, but snoozing would silently bring them back
+                    if pr['workboard_fields']['status'] == PullRequestStatus.DELETED:
+                        logging.warning('PR %r is deleted, thus cannot be snoozed', pr_url)
+                        skipped.append({'pr_url': pr_url, 'reason': 'deleted'})
+                        continue
+
+                    self._snooze_until_time(pr, snooze_until, 'by user (bulk snooze)')
+                    self._store_pull_request(pr)
+
+                if skipped:
+                    # Shown once on the next board load
+                    self.db.set('bulk-snooze-skipped', skipped, expire=300)
 
             # Back to homepage (full reload - yes this is a very simple web app!)
             self.send_response(303)
             self.send_header('Location', '/')
             self.end_headers()
-        elif self.path == '/pr/mark-must-review':
+        elif self.path == '/pr/snooze-after-activity':
             params = self._get_protected_post_params()
 
             pr_url = params['pr_url']
             if not isinstance(pr_url, str) or len(pr_url) > 300:
                 raise ValueError('Invalid pr_url')
 
-            logging.info('Marking PR %r as must-review', pr_url)
+            days = int(params['days'])
+            if not 1 <= days <= 30:
+                raise ValueError('Invalid days')
+            duration_seconds = 86400 * days
+
+            # Need the latest `updatedAt` value since it's the base for the snooze end
+            self._refetch_and_store_github_pr(pr_url)
 
             with self.db.transact():
-                pull_requests = self.db['pull_requests']
-                pr = pull_requests[pr_url]
-                pr['workboard_fields']['status'] = PullRequestStatus.MUST_REVIEW
-                pr['workboard_fields']['last_change'] = time.time()
-                self._validate_pull_requests(pull_requests)
-                self.db.set('pull_requests', pull_requests)
+                pr = self._get_pull_request(pr_url)
+
+                # Continued activity shouldn't keep the PR away forever
+                snooze_until_max = time.time() + 4 * duration_seconds
+                snooze_until = activity_based_snooze_until(
+                    pr['github_fields']['updatedAt'], duration_seconds, snooze_until_max)
+                if snooze_until <= time.time() + 60:
+                    raise ValueError(
+                        f'PR had no activity in the last {days} day(s), so this snooze would end immediately')
+
+                logging.info(
+                    'Snoozing PR %r for %d day(s) after last activity (currently until %r)', pr_url, days, snooze_until)
+
+                self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_TIME, 'by user')
+                pr['workboard_fields']['snooze_until'] = snooze_until
+                pr['workboard_fields']['snooze_after_activity_seconds'] = duration_seconds
+                pr['workboard_fields']['snooze_until_max'] = snooze_until_max
+                self._store_pull_request(pr)
                 self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
 
             # Back to homepage (full reload - yes this is a very simple web app!)
             self.send_response(303)
             self.send_header('Location', '/')
             self.end_headers()
-        elif self.path == '/pr/reviewed-delete-on-merge':
+        elif self.path == '/pr/snooze-until-smaller':
             params = self._get_protected_post_params()
 
             pr_url = params['pr_url']
             if not isinstance(pr_url, str) or len(pr_url) > 300:
                 raise ValueError('Invalid pr_url')
 
-            logging.info('Marking PR %r as reviewed-delete-on-merge', pr_url)
+            max_changed_lines = int(params['max_changed_lines'])
+            if not 1 <= max_changed_lines <= 1_000_000:
+                raise ValueError('Invalid max_changed_lines')
+
+            # Need the current size to tell whether the PR is too large at all
+            self._refetch_and_store_github_pr(pr_url)
 
             with self.db.transact():
-                pull_requests = self.db['pull_requests']
-                pr = pull_requests[pr_url]
-                pr['workboard_fields']['status'] = PullRequestStatus.REVIEWED_DELETE_ON_MERGE
-                pr['workboard_fields']['last_change'] = time.time()
-                pr['workboard_fields']['bring_back_to_review_if_not_merged_until'] = time.time() + 3600 * 4
-                self._validate_pull_requests(pull_requests)
-                self.db.set('pull_requests', pull_requests)
+                pr = self._get_pull_request(pr_url)
+
+                if is_smaller_than(pr['github_fields'], max_changed_lines):
+                    raise ValueError(f'PR already has less than {max_changed_lines} changed lines')
+
+                logging.info('Snoozing PR %r until it has less than %d changed lines', pr_url, max_changed_lines)
+
+                self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_SMALLER, 'by user')
+                pr['workboard_fields']['snooze_until_smaller_than'] = max_changed_lines
+                pr['workboard_fields']['snooze_until_smaller_max'] = time.time() + SNOOZE_UNTIL_SMALLER_MAX_SECONDS
+                self._store_pull_request(pr)
                 self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
 
             # Back to homepage (full reload - yes this is a very simple web app!)
             self.send_response(303)
             self.send_header('Location', '/')
             self.end_headers()
-        elif self.path == '/pr/snooze-until-mentioned':
+        elif self.path == '/pr/snooze-until-other-merged':
             params = self._get_protected_post_params()
 
             pr_url = params['pr_url']
             if not isinstance(pr_url, str) or len(pr_url) > 300:
                 raise ValueError('Invalid pr_url')
 
-            logging.info('Snoozing PR %r until user is mentioned', pr_url)
+            other_pr_url = params['other_pr_url']
+            if not isinstance(other_pr_url, str) or len(other_pr_url) > 1000:
+                raise ValueError('Invalid other_pr_url')
+            other_pr_url, _ = parse_pull_request_url(other_pr_url, self.github_host)
+
+            try:
+                other_pr_state = self._fetch_pr_state(other_pr_url, use_cache=False)
+            except GitHubCommandError as e:
+                if gone_status_for_error(e) is None:
+                    raise
+                raise ValueError(f'PR {other_pr_url!r} does not exist or its repo is not accessible') from e
+            if other_pr_state != 'OPEN':
+                raise ValueError(f'PR {other_pr_url!r} is not open anymore (state {other_pr_state!r})')
 
             with self.db.transact():
-                pull_requests = self.db['pull_requests']
-                pr = pull_requests[pr_url]
-                pr['workboard_fields']['status'] = PullRequestStatus.SNOOZED_UNTIL_MENTIONED
-                pr['workboard_fields']['last_change'] = time.time()
-                self._validate_pull_requests(pull_requests)
-                self.db.set('pull_requests', pull_requests)
+                if creates_snooze_cycle(self._get_pull_requests(), pr_url, other_pr_url):
+                    raise ValueError(f'PR {other_pr_url!r} (indirectly) waits for this PR to be merged')
+
+                pr = self._get_pull_request(pr_url)
+
+                logging.info('Snoozing PR %r until PR %r is merged', pr_url, other_pr_url)
+
+                self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_OTHER_MERGED, 'by user')
+                pr['workboard_fields']['snooze_until_merged_pr_url'] = other_pr_url
+                self._store_pull_request(pr)
                 self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
 
             # Back to homepage (full reload - yes this is a very simple web app!)
             self.send_response(303)
             self.send_header('Location', '/')
             self.end_headers()
-        elif self.path == '/pr/snooze-until-time':
+        elif self.path == '/pr/snooze-until-workflow':
             params = self._get_protected_post_params()
 
             pr_url = params['pr_url']
             if not isinstance(pr_url, str) or len(pr_url) > 300:
                 raise ValueError('Invalid pr_url')
 
-            logging.info('Snoozing PR %r for 1 day', pr_url)
+            workflow_name = params['workflow'].strip()
+            if not workflow_name or len(workflow_name) > 200:
+                raise ValueError('Invalid workflow')
+
+            conclusion = workflow_conclusion(self._fetch_checks(pr_url, use_cache=False), workflow_name)
+            if conclusion is None:
+                raise ValueError(f'PR has no workflow or check named {workflow_name!r}')
+            if conclusion != 'pending':
+                raise ValueError(f'Workflow {workflow_name!r} already completed with conclusion {conclusion!r}')
 
             with self.db.transact():
-                pull_requests = self.db['pull_requests']
-                pr = pull_requests[pr_url]
-                pr['workboard_fields']['status'] = PullRequestStatus.SNOOZED_UNTIL_TIME
-                pr['workboard_fields']['last_change'] = time.time()
-                pr['workboard_fields']['snooze_until'] = time.time() + 86400
-                self._validate_pull_requests(pull_requests)
-                self.db.set('pull_requests', pull_requests)
+                pr = self._get_pull_request(pr_url)
+
+                logging.info('Snoozing PR %r until workflow %r completes', pr_url, workflow_name)
+
+                self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_WORKFLOW, 'by user')
+                pr['workboard_fields']['snooze_until_workflow'] = workflow_name
+                pr['workboard_fields']['snooze_until_workflow_max'] = time.time() + SNOOZE_UNTIL_WORKFLOW_MAX_SECONDS
+                self._store_pull_request(pr)
                 self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
 
             # Back to homepage (full reload - yes this is a very simple web app!)
@@ -583,18 +4118,15 @@ class ServerHandler(http.server.SimpleHTTPRequestHandler):
             self._refetch_and_store_github_pr(pr_url)
 
             with self.db.transact():
-                pull_requests = self.db['pull_requests']
-                pr = pull_requests[pr_url]
+                pr = self._get_pull_request(pr_url)
 
                 snooze_until_updated_at_changed_from = pr['github_fields']['updatedAt']
                 logging.info(
                     'Snoozing PR %r until updatedAt changed away from %r', pr_url, snooze_until_updated_at_changed_from)
 
-                pr['workboard_fields']['status'] = PullRequestStatus.SNOOZED_UNTIL_UPDATE
-                pr['workboard_fields']['last_change'] = time.time()
+                self._set_status(pr, PullRequestStatus.SNOOZED_UNTIL_UPDATE, 'by user')
                 pr['workboard_fields']['snooze_until_updated_at_changed_from'] = snooze_until_updated_at_changed_from
-                self._validate_pull_requests(pull_requests)
-                self.db.set('pull_requests', pull_requests)
+                self._store_pull_request(pr)
                 self.db.set('last-clicked-github-pr-url', pr_url, expire=3600 * 4)
 
             # Back to homepage (full reload - yes this is a very simple web app!)
@@ -606,12 +4138,364 @@ class ServerHandler(http.server.SimpleHTTPRequestHandler):
             self.end_headers()
 
 
-def main():
+def get_pull_requests(db):
+    """
+    All stored PRs as GitHub PR URL => {'github_fields': {...}, 'workboard_fields': {...}}. Each PR is stored under
+    its own key, so that updating one PR doesn't have to rewrite all of them.
+    """
+
+    pull_requests = {}
+    for key in db:
+        if key.startswith(PR_KEY_PREFIX):
+            pr = db.get(key)
+            # May have expired in the meantime
+            if pr is not None:
+                pull_requests[key[len(PR_KEY_PREFIX):]] = pr
+    return pull_requests
+
+
+def with_status_history_entry(history, entry):
+    """
+    Appends to a PR's status history, keeping only the latest entries.
+
+    >>> history = [{'to': str(n)} for n in range(MAX_STATUS_HISTORY_ENTRIES)]
+    >>> history = with_status_history_entry(history, {'to': 'new'})
+    >>> len(history), history[0], history[-1]
+    (50, {'to': '1'}, {'to': 'new'})
+    """
+
+    return [*history, entry][-MAX_STATUS_HISTORY_ENTRIES:]
+
+
+def expired_deleted_pr_urls(pull_requests, now):
+    """
+    Deleted PRs whose retention time is over. Restored PRs don't count, even if they still had `delete_after` set.
+    Deleted PRs without `delete_after`, which only older versions or broken imports could store, count as expired.
+
+    >>> pull_requests = {
+    ...     'https://github.com/a/b/pull/1': {'workboard_fields': {'status': 'deleted', 'delete_after': 1000}},
+    ...     'https://github.com/a/b/pull/2': {'workboard_fields': {'status': 'deleted', 'delete_after': 1001}},
+    ...     'https://github.com/a/b/pull/3': {'workboard_fields': {'status': 'must-review', 'delete_after': 500}},
+    ...     'https://github.com/a/b/pull/4': {'workboard_fields': {'status': 'must-review'}},
+    ... }
+    >>> expired_deleted_pr_urls(pull_requests, now=1000)
+    ['https://github.com/a/b/pull/1']
+    >>> expired_deleted_pr_urls(pull_requests, now=999)
+    []
+    >>> expired_deleted_pr_urls({'https://github.com/a/b/pull/5': {'workboard_fields': {'status': 'deleted'}}}, now=0)
+    ['https://github.com/a/b/pull/5']
+    """
+
+    return sorted(
+        pr_url
+        for pr_url, pr in pull_requests.items()
+        if pr['workboard_fields']['status'] == PullRequestStatus.DELETED
+        and pr['workboard_fields'].get('delete_after', 0) <= now
+    )
+
+
+def purge_expired_deleted_pull_requests(db):
+    """
+    Deleted PRs are otherwise only removed once they get refreshed, which doesn't happen e.g. for ignored repos or
+    while GitHub is unreachable.
+    """
+
+    with db.transact():
+        pr_urls = expired_deleted_pr_urls(get_pull_requests(db), time.time())
+        for pr_url in pr_urls:
+            db.pop(PR_KEY_PREFIX + pr_url)
+            db.pop(STATUS_HISTORY_KEY_PREFIX + pr_url)
+    if pr_urls:
+        logging.info('Purged %d deleted PR(s) from database after their retention time', len(pr_urls))
+
+
+def migrate_snoozed_status(pull_requests):
+    """
+    >>> pull_requests = {'https://github.com/a/b/pull/1': {'workboard_fields': {
+    ...     'status': 'snoozed', 'snooze_until_updated_at_changed_from': '2024-01-01T00:00:00Z'}}}
+    >>> migrate_snoozed_status(pull_requests)
+    >>> print(pull_requests['https://github.com/a/b/pull/1']['workboard_fields']['status'])
+    snoozed-until-update
+    """
+
+    for pr_url, pr in pull_requests.items():
+        if (pr['workboard_fields']['status'] == 'snoozed'
+                and pr['workboard_fields'].get('snooze_until_updated_at_changed_from')):
+            logging.info('Migrating `snoozed` status value for PR %r', pr_url)
+            pr['workboard_fields']['status'] = PullRequestStatus.SNOOZED_UNTIL_UPDATE
+
+
+def backfill_last_change(pull_requests):
+    """
+    Very old entries may lack `last_change`, which sorting by last change relies on.
+
+    >>> pull_requests = {'https://github.com/a/b/pull/1': {
+    ...     'github_fields': {'updatedAt': '2024-01-01T00:00:00Z'}, 'workboard_fields': {'status': 'must-review'}}}
+    >>> backfill_last_change(pull_requests)
+    >>> pull_requests['https://github.com/a/b/pull/1']['workboard_fields']['last_change']
+    1704067200
+    """
+
+    for pr in pull_requests.values():
+        pr['workboard_fields'].setdefault(
+            'last_change', github_datetime_to_timestamp(pr['github_fields']['updatedAt']))
+
+
+def backfill_last_visited_at(pull_requests):
+    """
+    PRs from before read/unread tracking count as read as of their last known activity, so that the whole board doesn't
+    turn unread at once.
+
+    >>> pull_requests = {'https://github.com/a/b/pull/1': {
+    ...     'github_fields': {'updatedAt': '2024-01-01T00:00:00Z'},
+    ...     'workboard_fields': {'status': 'must-review', 'last_change': 1000}}}
+    >>> backfill_last_visited_at(pull_requests)
+    >>> pull_requests['https://github.com/a/b/pull/1']['workboard_fields']['last_visited_at']
+    1704067200
+    """
+
+    for pr in pull_requests.values():
+        pr['workboard_fields'].setdefault(
+            'last_visited_at',
+            max(pr['workboard_fields']['last_change'], github_datetime_to_timestamp(pr['github_fields']['updatedAt'])))
+
+
+# Stored data migrations. Applying the migration at index N results in schema version N+1. Only ever append to this
+# list, and keep each migration idempotent.
+SCHEMA_MIGRATIONS = [
+    migrate_snoozed_status,
+    backfill_last_change,
+    backfill_last_visited_at,
+]
+
+
+def migrate_pull_requests(pull_requests, schema_version):
+    """
+    Applies all migrations which are newer than `schema_version` and returns the new schema version.
+
+    >>> pull_requests = {'https://github.com/a/b/pull/1': {
+    ...     'github_fields': {'updatedAt': '2024-01-01T00:00:00Z'}, 'workboard_fields': {'status': 'snoozed'}}}
+    >>> migrate_pull_requests(pull_requests, schema_version=1) == len(SCHEMA_MIGRATIONS)
+    True
+    >>> pull_requests['https://github.com/a/b/pull/1']['workboard_fields']  # first migration was skipped
+    {'status': 'snoozed', 'last_change': 1704067200, 'last_visited_at': 1704067200}
+    >>> migrate_pull_requests(pull_requests, schema_version=len(SCHEMA_MIGRATIONS)) == len(SCHEMA_MIGRATIONS)
+    True
+    """
+
+    for version, migration in enumerate(SCHEMA_MIGRATIONS[schema_version:], start=schema_version + 1):
+        logging.info('Migrating database to schema version %d (%s)', version, migration.__name__)
+        migration(pull_requests)
+    return len(SCHEMA_MIGRATIONS)
+
+
+def setup_logging():
     logging.basicConfig(
         level=os.environ.get('LOGLEVEL', 'INFO').upper(),
         format='%(asctime)s %(levelname)-8s %(message)s',
     )
 
+
+def pull_requests_from_export_entry(entry):
+    """
+    >>> pull_requests_from_export_entry({'key': 'pull_request.https://x/1', 'value': {'github_fields': {}}})
+    {'https://x/1': {'github_fields': {}}}
+    >>> pull_requests_from_export_entry({'key': 'pull_requests', 'value': {'https://x/1': {'github_fields': {}}}})
+    {'https://x/1': {'github_fields': {}}}
+    >>> pull_requests_from_export_entry({'key': 'initialized', 'value': True})
+    {}
+    """
+
+    if entry['key'].startswith(PR_KEY_PREFIX):
+        return {entry['key'][len(PR_KEY_PREFIX):]: entry['value']}
+    # Older exports have all PRs in one value
+    if entry['key'] == 'pull_requests':
+        if not isinstance(entry['value'], dict):
+            raise ValueError('Invalid `pull_requests` value in export')
+        return entry['value']
+    return {}
+
+
+def migrate_database_export(dump):
+    """
+    Brings the PRs of an export (see `/export`) to the current schema version in place, like `main` does for the
+    database. Exports from before schema versioning have no `schema_version` entry, which means version 0. Malformed
+    entries are left alone for `validate_database_export` to reject.
+
+    >>> pr = {
+    ...     'github_fields': {'url': 'https://github.com/a/b/pull/1', 'updatedAt': '2024-01-01T00:00:00Z'},
+    ...     'workboard_fields': {'status': 'snoozed', 'snooze_until_updated_at_changed_from': '2024-01-01T00:00:00Z'},
+    ... }
+    >>> entries = [{'key': 'pull_request.https://github.com/a/b/pull/1', 'value': pr, 'expire_time': None}]
+    >>> dump = {'format': 'workboard-export', 'version': 1, 'entries': entries}
+    >>> migrate_database_export(dump)
+    >>> print(pr['workboard_fields']['status'], pr['workboard_fields']['last_change'])
+    snoozed-until-update 1704067200
+    >>> dump['entries'][-1] == {'key': 'schema_version', 'value': len(SCHEMA_MIGRATIONS), 'expire_time': None}
+    True
+    >>> dump['entries'][-1]['value'] = len(SCHEMA_MIGRATIONS) + 1
+    >>> migrate_database_export(dump)  # doctest: +ELLIPSIS
+    Traceback (most recent call last):
+    ...
+    ValueError: Export has schema version ..., but this workboard version only supports up to ...
+    """
+
+    if not isinstance(dump, dict) or not isinstance(dump.get('entries'), list):
+        return
+    entries = [entry for entry in dump['entries'] if isinstance(entry, dict) and isinstance(entry.get('key'), str)]
+
+    schema_version_entry = next((entry for entry in entries if entry['key'] == 'schema_version'), None)
+    if schema_version_entry is None:
+        schema_version_entry = {'key': 'schema_version', 'value': 0, 'expire_time': None}
+        dump['entries'].append(schema_version_entry)
+    schema_version = schema_version_entry.get('value')
+    if not isinstance(schema_version, int) or schema_version < 0:
+        raise ValueError('Invalid `schema_version` value in export')
+    if schema_version > len(SCHEMA_MIGRATIONS):
+        raise ValueError(
+            f'Export has schema version {schema_version}, but this workboard version only supports up to '
+            f'{len(SCHEMA_MIGRATIONS)}. Please update workboard.')
+
+    pull_requests = {}
+    for entry in entries:
+        for pr_url, pr in pull_requests_from_export_entry(entry).items():
+            if (isinstance(pr, dict)
+                    and isinstance(pr.get('github_fields'), dict)
+                    and isinstance(pr.get('workboard_fields'), dict)):
+                pull_requests[pr_url] = pr
+    schema_version_entry['value'] = migrate_pull_requests(pull_requests, schema_version)
+
+
+def validate_database_export(dump):
+    """
+    Checks a JSON export (see `/export`) before anything gets written, so that a broken file can't corrupt the
+    database.
+
+    >>> pr = {'github_fields': {'url': 'https://github.com/a/b/pull/1'}, 'workboard_fields': {'status': 'merged'}}
+    >>> entries = [{'key': 'pull_request.https://github.com/a/b/pull/1', 'value': pr, 'expire_time': None}]
+    >>> validate_database_export({'format': 'workboard-export', 'version': 1, 'entries': entries})
+    >>> history = [{'at': 1704067200, 'from': 'unknown', 'to': 'merged', 'reason': 'merged on GitHub'}]
+    >>> entries.append({'key': 'status_history.https://github.com/a/b/pull/1', 'value': history, 'expire_time': None})
+    >>> validate_database_export({'format': 'workboard-export', 'version': 1, 'entries': entries})
+    >>> history[0]['at'] = 'yesterday'
+    >>> validate_database_export({'format': 'workboard-export', 'version': 1, 'entries': entries})
+    Traceback (most recent call last):
+    ...
+    ValueError: Invalid status history 'status_history.https://github.com/a/b/pull/1' in export
+    >>> # Exports from before each PR was stored under its own key
+    >>> entries = [{'key': 'pull_requests', 'value': {'https://github.com/a/b/pull/1': pr}, 'expire_time': None}]
+    >>> validate_database_export({'format': 'workboard-export', 'version': 1, 'entries': entries})
+    >>> validate_database_export({'format': 'workboard-export', 'version': 2, 'entries': entries})
+    Traceback (most recent call last):
+    ...
+    ValueError: Not a workboard export or unsupported version
+    >>> entries[0]['value']['https://github.com/a/b/pull/1']['workboard_fields']['status'] = 'bogus'
+    >>> validate_database_export({'format': 'workboard-export', 'version': 1, 'entries': entries})
+    Traceback (most recent call last):
+    ...
+    ValueError: Invalid PR 'https://github.com/a/b/pull/1' in export
+    >>> # Deleted PRs need a retention time (see `expired_deleted_pr_urls`)
+    >>> entries[0]['value']['https://github.com/a/b/pull/1']['workboard_fields']['status'] = 'deleted'
+    >>> validate_database_export({'format': 'workboard-export', 'version': 1, 'entries': entries})
+    Traceback (most recent call last):
+    ...
+    ValueError: Invalid PR 'https://github.com/a/b/pull/1' in export
+    >>> entries[0]['value']['https://github.com/a/b/pull/1']['workboard_fields']['delete_after'] = 1704067200
+    >>> validate_database_export({'format': 'workboard-export', 'version': 1, 'entries': entries})
+    """
+
+    if (not isinstance(dump, dict)
+            or dump.get('format') != 'workboard-export'
+            or dump.get('version') != 1
+            or not isinstance(dump.get('entries'), list)):
+        raise ValueError('Not a workboard export or unsupported version')
+    for entry in dump['entries']:
+        if (not isinstance(entry, dict)
+                or set(entry.keys()) != {'key', 'value', 'expire_time'}
+                or not isinstance(entry['key'], str)
+                or not isinstance(entry['expire_time'], (int, float, type(None)))):
+            raise ValueError(f'Invalid entry in export: {entry!r:.200}')
+        # Shown as is on the PR details page, so it must have the same format as written by `_set_status`
+        if entry['key'].startswith(STATUS_HISTORY_KEY_PREFIX) and (
+                not isinstance(entry['value'], list)
+                or not all(
+                    isinstance(history_entry, dict)
+                    and set(history_entry.keys()) == {'at', 'from', 'to', 'reason'}
+                    and isinstance(history_entry['at'], (int, float))
+                    and all(isinstance(history_entry[k], str) for k in ('from', 'to', 'reason'))
+                    for history_entry in entry['value'])):
+            raise ValueError(f'Invalid status history {entry["key"]!r} in export')
+        for pr_url, pr in pull_requests_from_export_entry(entry).items():
+            if (not pr_url.startswith('http')
+                    or not isinstance(pr, dict)
+                    or set(pr.keys()) != {'github_fields', 'workboard_fields'}
+                    or not isinstance(pr['github_fields'], dict)
+                    or pr['github_fields'].get('url') != pr_url
+                    or not isinstance(pr['workboard_fields'], dict)
+                    or pr['workboard_fields'].get('status') not in set(PullRequestStatus)
+                    or (pr['workboard_fields']['status'] == PullRequestStatus.DELETED
+                        and not isinstance(pr['workboard_fields'].get('delete_after'), (int, float)))):
+                raise ValueError(f'Invalid PR {pr_url!r} in export')
+
+
+def import_database_main(args):
+    parser = argparse.ArgumentParser(
+        prog='main.py import', description='Import a JSON export of the database (see `/export`)')
+    parser.add_argument('file', help='Exported JSON file')
+    parser.add_argument(
+        '--replace',
+        action='store_true',
+        help='Remove all existing data first. By default, the export is merged into the database, with PRs from '
+        'the export replacing those with the same URL.')
+    args = parser.parse_args(args)
+
+    setup_logging()
+
+    with open(args.file) as f:
+        dump = json.load(f)
+    # Before validation, since old exports may contain status values which only exist before migration
+    migrate_database_export(dump)
+    validate_database_export(dump)
+
+    db_dir = os.path.abspath('workboard.db')
+    if not os.path.exists(db_dir):
+        raise RuntimeError(f'Please create the database directory {db_dir!r} first')
+    db = diskcache.Cache(db_dir)
+    try:
+        # Single transaction, so a failure doesn't leave the database half-imported
+        with db.transact():
+            if args.replace:
+                logging.info('Removing all %d existing database entries', len(db))
+                for key in list(db):
+                    db.pop(key)
+
+            now = time.time()
+            imported_count = 0
+            for entry in dump['entries']:
+                if entry['expire_time'] is not None and entry['expire_time'] <= now:
+                    continue
+                expire = None if entry['expire_time'] is None else entry['expire_time'] - now
+                # The imported PRs were migrated already. When merging, the database keeps its own version, so that
+                # its other PRs still get migrated on startup if needed.
+                if entry['key'] == 'schema_version' and not args.replace:
+                    continue
+                pull_requests = pull_requests_from_export_entry(entry)
+                if not pull_requests:
+                    db.set(entry['key'], entry['value'], expire=expire)
+                for pr_url, pr in pull_requests.items():
+                    pr['workboard_fields']['status'] = PullRequestStatus(pr['workboard_fields']['status'])
+                    db.set(PR_KEY_PREFIX + pr_url, pr, expire=expire)
+                imported_count += 1
+            db.set('initialized', True, expire=None)
+    finally:
+        db.close()
+
+    logging.info('Imported %d database entries from %r', imported_count, args.file)
+
+
+def main():
+    setup_logging()
+
     # Load config from file
     config_file_path = os.path.abspath('workboard.yaml')
     config_file_example_path = os.path.abspath('workboard.yaml.example')
@@ -621,12 +4505,15 @@ def main():
             f'You can copy-paste from {config_file_example_path!r}')
     with open(config_file_path) as f:
         cfg = yaml.safe_load(f)
-    def get_cfg_path(*path):
+    no_default = object()
+    def get_cfg_path(*path, default=no_default):
         current = cfg
         message = ''
         for p in path:
             message = message + ('.' if message else '') + p
             if p not in current:
+                if default is not no_default:
+                    return default
                 raise RuntimeError(
                     f'Config file {config_file_path!r} is missing key {message!r}. '
                     f'Please check in {config_file_example_path!r} what it should look like.')
@@ -634,6 +4521,192 @@ def main():
         return current
     ServerHandler.github_user = get_cfg_path('github', 'user')
 
+    github_host = get_cfg_path('github', 'host', default=None)
+    if github_host is not None:
+        if not isinstance(github_host, str) or not re.fullmatch(r'[A-Za-z0-9.-]+(:[0-9]+)?', github_host):
+            raise RuntimeError('Config key `github.host` must be a host name such as `github.example.com`')
+        logging.info('Using GitHub Enterprise host %r', github_host)
+        ServerHandler.github_host = github_host
+
+    # Searching for another user's PRs would work just fine and silently show the wrong board
+    gh_env = dict(os.environ, GH_HOST=github_host) if github_host is not None else None
+    proc = subprocess.run(
+        ['gh', 'api', 'user', '--jq', '.login'], capture_output=True, encoding='utf-8', env=gh_env, check=False)
+    if proc.returncode:
+        logging.warning(
+            'Could not check which GitHub user `gh` is logged in as (error output: %r). Is `gh auth login` done?',
+            proc.stderr)
+    elif proc.stdout.strip().lower() != ServerHandler.github_user.lower():
+        raise RuntimeError(
+            f'Config key `github.user` is {ServerHandler.github_user!r}, but `gh` is logged in as '
+            f'{proc.stdout.strip()!r}. Please fix the configuration or log in with the right account.')
+    elif proc.stdout.strip() != ServerHandler.github_user:
+        # Logins are case-insensitive, but GitHub data always has the canonical spelling, which many checks compare to
+        logging.info('Using login %r as returned by `gh` instead of %r', proc.stdout.strip(), ServerHandler.github_user)
+        ServerHandler.github_user = proc.stdout.strip()
+
+    exclude_title_patterns = get_cfg_path('github', 'exclude_title_patterns', default=[])
+    if not isinstance(exclude_title_patterns, list):
+        raise RuntimeError('Config key `github.exclude_title_patterns` must be a list of regular expressions')
+    try:
+        ServerHandler.exclude_title_patterns = [
+            re.compile(pattern, re.IGNORECASE) for pattern in exclude_title_patterns]
+    except (re.error, TypeError) as e:
+        raise RuntimeError(f'Invalid regular expression in config key `github.exclude_title_patterns`: {e}') from e
+
+    github_fetch_concurrency = get_cfg_path(
+        'github', 'fetch_concurrency', default=ServerHandler.github_fetch_concurrency)
+    if (isinstance(github_fetch_concurrency, bool)
+            or not isinstance(github_fetch_concurrency, int)
+            or not 1 <= github_fetch_concurrency <= 16):
+        raise RuntimeError('Config key `github.fetch_concurrency` must be a number between 1 and 16')
+    ServerHandler.github_fetch_concurrency = github_fetch_concurrency
+
+    github_max_attempts = get_cfg_path('github', 'max_attempts', default=ServerHandler.github_max_attempts)
+    if (isinstance(github_max_attempts, bool)
+            or not isinstance(github_max_attempts, int)
+            or not 1 <= github_max_attempts <= 10):
+        raise RuntimeError('Config key `github.max_attempts` must be a number between 1 and 10')
+    ServerHandler.github_max_attempts = github_max_attempts
+
+    awaiting_response_hours = get_cfg_path('github', 'awaiting_response_hours', default=None)
+    if awaiting_response_hours is not None and (
+            isinstance(awaiting_response_hours, bool)
+            or not isinstance(awaiting_response_hours, (int, float))
+            or awaiting_response_hours <= 0):
+        raise RuntimeError('Config key `github.awaiting_response_hours` must be a positive number of hours')
+    ServerHandler.awaiting_response_hours = awaiting_response_hours
+
+    mention_lookback_hours = get_cfg_path('github', 'mention_lookback_hours', default=0)
+    if (isinstance(mention_lookback_hours, bool)
+            or not isinstance(mention_lookback_hours, (int, float))
+            or mention_lookback_hours < 0):
+        raise RuntimeError('Config key `github.mention_lookback_hours` must be a non-negative number of hours')
+    ServerHandler.mention_lookback_hours = mention_lookback_hours
+
+    snooze_drafts = get_cfg_path('github', 'snooze_drafts', default=False)
+    if not isinstance(snooze_drafts, bool):
+        raise RuntimeError('Config key `github.snooze_drafts` must be a boolean')
+    ServerHandler.snooze_drafts = snooze_drafts
+
+    delete_closed_unreviewed = get_cfg_path('github', 'delete_closed_unreviewed', default=False)
+    if not isinstance(delete_closed_unreviewed, bool):
+        raise RuntimeError('Config key `github.delete_closed_unreviewed` must be a boolean')
+    ServerHandler.delete_closed_unreviewed = delete_closed_unreviewed
+
+    saved_searches = get_cfg_path('github', 'saved_searches', default=[])
+    if not isinstance(saved_searches, list):
+        raise RuntimeError('Config key `github.saved_searches` must be a list')
+    for saved_search in saved_searches:
+        if (not isinstance(saved_search, dict)
+                or set(saved_search.keys()) != {'name', 'query'}
+                or not isinstance(saved_search['name'], str)
+                or not isinstance(saved_search['query'], str)):
+            raise RuntimeError('Each item of config key `github.saved_searches` must have a `name` and `query`')
+        if 'is:pr' not in saved_search['query'].split():
+            raise RuntimeError(
+                f'Query of saved search {saved_search["name"]!r} must contain `is:pr` (config key '
+                '`github.saved_searches`)')
+    if len({saved_search['name'] for saved_search in saved_searches}) != len(saved_searches):
+        raise RuntimeError('Names in config key `github.saved_searches` must be unique')
+    ServerHandler.saved_searches = saved_searches
+
+    important_reviewers = get_cfg_path('github', 'important_reviewers', default=[])
+    if not isinstance(important_reviewers, list) or not all(isinstance(login, str) for login in important_reviewers):
+        raise RuntimeError('Config key `github.important_reviewers` must be a list of GitHub usernames')
+    ServerHandler.important_reviewers = important_reviewers
+
+    reviewed_bring_back_after_hours = get_cfg_path(
+        'reviewed', 'bring_back_after_hours', default=ServerHandler.reviewed_bring_back_after_hours)
+    if (isinstance(reviewed_bring_back_after_hours, bool)
+            or not isinstance(reviewed_bring_back_after_hours, (int, float))
+            or reviewed_bring_back_after_hours <= 0):
+        raise RuntimeError('Config key `reviewed.bring_back_after_hours` must be a positive number of hours')
+    ServerHandler.reviewed_bring_back_after_hours = reviewed_bring_back_after_hours
+
+    snoozed_on_merged_or_closed = get_cfg_path(
+        'snoozed', 'on_merged_or_closed', default=ServerHandler.snoozed_on_merged_or_closed)
+    if snoozed_on_merged_or_closed not in ('surface', 'delete'):
+        raise RuntimeError('Config key `snoozed.on_merged_or_closed` must be `surface` or `delete`')
+    ServerHandler.snoozed_on_merged_or_closed = snoozed_on_merged_or_closed
+
+    try:
+        ServerHandler.timezone = load_timezone(get_cfg_path('timezone', default=None))
+    except ValueError as e:
+        raise RuntimeError(f'Config key `timezone` must be a time zone name: {e}') from e
+
+    api_enabled = get_cfg_path('api', 'enabled', default=False)
+    if not isinstance(api_enabled, bool):
+        raise RuntimeError('Config key `api.enabled` must be a boolean')
+    ServerHandler.api_enabled = api_enabled
+
+    webhook_secret = get_cfg_path('webhook', 'secret', default=None)
+    if webhook_secret is not None and (not isinstance(webhook_secret, str) or len(webhook_secret) < 16):
+        raise RuntimeError('Config key `webhook.secret` must be a string of at least 16 characters')
+    ServerHandler.webhook_secret = webhook_secret
+
+    on_repo_gone = get_cfg_path('github', 'on_repo_gone', default=ServerHandler.on_repo_gone)
+    if on_repo_gone not in ('mark', 'delete'):
+        raise RuntimeError('Config key `github.on_repo_gone` must be `mark` or `delete`')
+    ServerHandler.on_repo_gone = on_repo_gone
+
+    def validate_retention_days(days, message):
+        if isinstance(days, bool) or not isinstance(days, (int, float)) or days <= 0:
+            raise RuntimeError(f'Config key {message!r} must be a positive number of days')
+        return days
+    ServerHandler.retention_deleted_days = validate_retention_days(
+        get_cfg_path('retention', 'deleted_days', default=ServerHandler.retention_deleted_days),
+        'retention.deleted_days')
+    retention_deleted_days_by_status = get_cfg_path('retention', 'deleted_days_by_status', default={})
+    if not isinstance(retention_deleted_days_by_status, dict):
+        raise RuntimeError('Config key `retention.deleted_days_by_status` must map statuses to days')
+    for status, days in retention_deleted_days_by_status.items():
+        if status not in set(PullRequestStatus) - {PullRequestStatus.DELETED}:
+            raise RuntimeError(f'Unknown status {status!r} in config key `retention.deleted_days_by_status`')
+        validate_retention_days(days, f'retention.deleted_days_by_status.{status}')
+    ServerHandler.retention_deleted_days_by_status = retention_deleted_days_by_status
+    retention_hard_delete = get_cfg_path('retention', 'hard_delete', default=False)
+    if not isinstance(retention_hard_delete, bool):
+        raise RuntimeError('Config key `retention.hard_delete` must be a boolean')
+    ServerHandler.retention_hard_delete = retention_hard_delete
+    logging.info(
+        'Keeping deleted PRs for %s day(s)%s',
+        ServerHandler.retention_deleted_days,
+        ''.join(
+            f', {status}: {days} day(s)'
+            for status, days in sorted(retention_deleted_days_by_status.items())))
+    if retention_hard_delete:
+        logging.info('PRs deleted by you are removed from the database right away')
+
+    if get_cfg_path('hooks', 'enabled', default=False):
+        hooks_timeout_seconds = get_cfg_path('hooks', 'timeout_seconds', default=ServerHandler.hooks_timeout_seconds)
+        if isinstance(hooks_timeout_seconds, bool) or not isinstance(hooks_timeout_seconds, (int, float)) \
+                or not 0 < hooks_timeout_seconds <= 300:
+            raise RuntimeError('Config key `hooks.timeout_seconds` must be a number of seconds between 0 and 300')
+        ServerHandler.hooks_timeout_seconds = hooks_timeout_seconds
+
+        hooks_by_status = get_cfg_path('hooks', 'on_status', default={})
+        if not isinstance(hooks_by_status, dict):
+            raise RuntimeError('Config key `hooks.on_status` must map statuses to lists of commands')
+        for status, commands in hooks_by_status.items():
+            if status not in set(PullRequestStatus):
+                raise RuntimeError(f'Unknown status {status!r} in config key `hooks.on_status`')
+            if (not isinstance(commands, list)
+                    or not all(isinstance(command, list) and command for command in commands)
+                    or not all(isinstance(arg, str) for command in commands for arg in command)):
+                raise RuntimeError(
+                    f'Config key `hooks.on_status.{status}` must be a list of commands, '
+                    'each given as list of arguments')
+        ServerHandler.hooks_by_status = hooks_by_status
+
+        hooks_on_mention = get_cfg_path('hooks', 'on_mention', default=[])
+        if (not isinstance(hooks_on_mention, list)
+                or not all(isinstance(command, list) and command for command in hooks_on_mention)
+                or not all(isinstance(arg, str) for command in hooks_on_mention for arg in command)):
+            raise RuntimeError(
+                'Config key `hooks.on_mention` must be a list of commands, each given as list of arguments')
+        ServerHandler.hooks_on_mention = hooks_on_mention
+
     db_dir = os.path.abspath('workboard.db')
     if not os.path.exists(db_dir):
         raise RuntimeError(
@@ -650,6 +4723,29 @@ def main():
     if len(ServerHandler.db) == 0:
         logging.warning(f'Database {db_dir!r} is empty (assuming this is a first-time startup)')
         ServerHandler.db.set('initialized', True, expire=None)
+        ServerHandler.db.set('schema_version', len(SCHEMA_MIGRATIONS), expire=None)
+
+    with ServerHandler.db.transact():
+        # Databases from before schema versioning have version 0
+        schema_version = ServerHandler.db.get('schema_version', 0)
+        if schema_version > len(SCHEMA_MIGRATIONS):
+            raise RuntimeError(
+                f'Database {db_dir!r} has schema version {schema_version}, but this workboard version only supports '
+                f'up to {len(SCHEMA_MIGRATIONS)}. Please update workboard.')
+        # All PRs used to be stored in one value, which made every update rewrite all of them
+        legacy_pull_requests = ServerHandler.db.pop('pull_requests', None)
+        if legacy_pull_requests is not None:
+            logging.info('Migrating %d PR(s) to be stored under separate keys', len(legacy_pull_requests))
+        if legacy_pull_requests is not None or schema_version < len(SCHEMA_MIGRATIONS):
+            pull_requests = (
+                legacy_pull_requests if legacy_pull_requests is not None else get_pull_requests(ServerHandler.db))
+            schema_version = migrate_pull_requests(pull_requests, schema_version)
+            for pr_url, pr in pull_requests.items():
+                ServerHandler._validate_pull_request(pr)
+                ServerHandler.db.set(PR_KEY_PREFIX + pr_url, pr)
+            ServerHandler.db.set('schema_version', schema_version, expire=None)
+
+    purge_expired_deleted_pull_requests(ServerHandler.db)
 
     httpd = socketserver.TCPServer(('localhost', PORT), ServerHandler, bind_and_activate=False)
     httpd.allow_reuse_address = True
@@ -667,4 +4763,6 @@ if __name__ == '__main__':
     if doctest.testmod()[0]:
         sys.exit(1)
 
+    if sys.argv[1:2] == ['import']:
+        sys.exit(import_database_main(sys.argv[2:]))
     sys.exit(main())